@@ -1,6 +1,7 @@
 package v1beta1
 
 import (
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -14,6 +15,43 @@ const (
 	InterfaceTypePrivate InterfaceType = "Private"
 )
 
+// UserDataFormat is the format of the rendered user data.
+type UserDataFormat string
+
+const (
+	// UserDataFormatJSON renders the Jsonnet output as compact JSON, as used by ignition.
+	UserDataFormatJSON UserDataFormat = "json"
+	// UserDataFormatYAML renders the Jsonnet output as YAML.
+	UserDataFormatYAML UserDataFormat = "yaml"
+	// UserDataFormatCloudInit renders the Jsonnet output as YAML prefixed with the #cloud-config header.
+	UserDataFormatCloudInit UserDataFormat = "cloud-init"
+	// UserDataFormatRaw uses the Jsonnet output verbatim. The template must evaluate to a string,
+	// for example by using std.manifestYamlDoc or std.manifestIni, or by returning a shell script.
+	UserDataFormatRaw UserDataFormat = "raw"
+)
+
+const (
+	// TokenValidCondition indicates whether the cloudscale API token used for the machine was accepted by the cloudscale API.
+	TokenValidCondition = "TokenValid"
+	// UserDataSecretsAllowedCondition indicates whether all secrets matching UserDataSecretSelector and all Jsonnet library secrets are allowed by the secret policy of the provider.
+	// Blocked secrets are not passed to the user data Jsonnet template.
+	UserDataSecretsAllowedCondition = "UserDataSecretsAllowed"
+	// UserDataUpToDateCondition indicates whether the user data currently rendered for the machine matches the user data the server was created with.
+	UserDataUpToDateCondition = "UserDataUpToDate"
+	// QuotaExceededCondition indicates whether creating the server of the machine would exceed the quota of the cloudscale project.
+	// The condition is true if neither Flavor nor any of the FlavorFallbacks fit into the quota.
+	// The message lists the missing resources.
+	QuotaExceededCondition = "QuotaExceeded"
+)
+
+// JsonnetLibraryLabel marks ConfigMaps and Secrets whose keys are importable from the user data Jsonnet template.
+// The label value must be "true".
+const JsonnetLibraryLabel = "machine-api-provider-cloudscale.appuio.io/jsonnet-library"
+
+// AllocatedAddressesAnnotation records the addresses allocated from Interface.AddressFromRange on a Machine.
+// The value is a JSON object mapping the interface index to the allocated address.
+const AllocatedAddressesAnnotation = "machine-api-provider-cloudscale.appuio.io/allocated-addresses"
+
 // CloudscaleMachineProviderSpec is the type that will be embedded in a Machine.Spec.ProviderSpec field
 // for a cloudscale virtual machine. It is used by the cloudscale machine actuator to create a single Machine.
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -26,23 +64,49 @@ type CloudscaleMachineProviderSpec struct {
 
 	// UserDataSecret is a reference to a secret that contains the UserData to apply to the instance.
 	// The secret must contain a key named userData. The value is evaluated using Jsonnet; it can be either pure JSON or a Jsonnet template.
+	// The output is rendered according to UserDataFormat.
 	// The Jsonnet template has access to the following variables:
 	// - std.extVar('context').machine: the Machine object. The name can be accessed via std.extVar('context').machine.metadata.name for example.
 	// - std.extVar('context').data: all keys from the UserDataSecret. For example, std.extVar('context').data.foo will access the value of the key foo.
 	// - std.extVar('context').secrets: all secrets matching UserDataSecretSelector. For example, std.extVar('context').secrets[0].metadata.name will access the name of the first secret.
+	// - std.extVar('context').machineSet: the MachineSet owning the Machine or null. Only fetched if accessed.
+	// - std.extVar('context').infrastructure: the cluster Infrastructure object or null if it does not exist. Only fetched if accessed.
+	// - std.extVar('context').zone and std.extVar('context').region: the zone of the machine and its region.
+	// - std.extVar('context').serverGroups: the UUIDs of the server groups the server is added to, including the ones for ServerGroupNames and AntiAffinityKey.
+	// - std.extVar('context').providerVersion: the version of the machine-api-provider-cloudscale.
 	// Also see UserDataSecretSelector.
+	// The template can import the keys of ConfigMaps and Secrets in the machine's namespace labeled with machine-api-provider-cloudscale.appuio.io/jsonnet-library=true.
+	// Every key is importable as lib/<key>, for example import 'lib/ignition.libsonnet'. No other imports are possible.
+	// Secrets blocked by the secret policy of the provider are not importable, see UserDataSecretSelector.
 	// +optional
 	UserDataSecret *corev1.LocalObjectReference `json:"userDataSecret,omitempty"`
 	// UserDataSecretSelector allows passing secrets with the matching selector into the user data Jsonnet context.
 	// Only secrets in the same namespace as the controller are considered.
+	// The provider can be configured to only pass secrets with a given label and to never pass secrets of given types.
+	// Blocked secrets are reported in the UserDataSecretsAllowed condition.
 	// +optional
 	// `null` means no secrets are passed.
 	// And empty selector means all secrets in the namespace are passed.
 	UserDataSecretSelector *metav1.LabelSelector `json:"userDataSecretSelector,omitempty"`
+	// UserDataFormat is the format of the rendered user data.
+	// Can be "json", "yaml", "cloud-init" or "raw".
+	// Defaults to "json".
+	// +optional
+	UserDataFormat UserDataFormat `json:"userDataFormat,omitempty"`
+	// CompressUserData allows compressing user data exceeding the maximum user data size of the provider.
+	// The user data is gzipped and merged from a base64 data URL by a minimal ignition config.
+	// Only supported for the "json" user data format with an ignition spec 3 config. The minimal ignition config uses the spec version of the user data, but at least 3.1.0.
+	// +optional
+	CompressUserData bool `json:"compressUserData,omitempty"`
+	// StoreRenderedUserData stores the rendered user data in a Secret owned by the Machine for debugging.
+	// The Secret is named after the Machine with the suffix -rendered-user-data and is referenced from the provider status.
+	// It is deleted together with the Machine.
+	// +optional
+	StoreRenderedUserData bool `json:"storeRenderedUserData,omitempty"`
 
 	// TokenSecret is a reference to the secret with the cloudscale API token.
 	// The secret must contain a key named token.
-	// If no token is provided, the operator will try to use the default token from CLOUDSCALE_API_TOKEN.
+	// If no token is provided, the operator will try to use the default token from --cloudscale-token-file or CLOUDSCALE_API_TOKEN.
 	// +optional
 	TokenSecret *corev1.LocalObjectReference `json:"tokenSecret,omitempty"`
 
@@ -50,7 +114,22 @@ type CloudscaleMachineProviderSpec struct {
 	// +optional
 	BaseDomain string `json:"baseDomain,omitempty"`
 	// Zone is the zone in which the machine will be created.
+	// Must be empty if Zones is set.
+	// +optional
 	Zone string `json:"zone"`
+	// Zones is a list of zones in which the machine can be created.
+	// The actuator chooses the zone with the fewest machines of the MachineSet of the machine, the first zone wins on a tie.
+	// The chosen zone is recorded in the machine.openshift.io/zone label and the provider status.
+	// +optional
+	Zones []MachineZone `json:"zones,omitempty"`
+	// ZoneFallbacks is a list of zones tried in order if the server can't be created in Zone for capacity reasons.
+	// Other errors are not retried in the fallback zones.
+	// Server groups for ServerGroupNames and AntiAffinityKey and networks and subnets selected by name or tags are resolved in the fallback zone.
+	// Server groups created for a zone the server could not be created in are deleted before falling back to the next zone.
+	// Can't be combined with Zones, ServerGroups, networks or subnets selected by UUID, AddressesFromPools or AddressFromRange.
+	// The zone the server was created in is recorded in the machine.openshift.io/zone label and the provider status.
+	// +optional
+	ZoneFallbacks []string `json:"zoneFallbacks,omitempty"`
 	// AntiAffinityKey is a key to use for anti-affinity. If set, the machine will be placed in different cloudscale server groups based on this key.
 	// The machines are automatically distributed across server groups with the same key.
 	// +optional
@@ -59,10 +138,23 @@ type CloudscaleMachineProviderSpec struct {
 	// Used for anti-affinity.
 	// https://www.cloudscale.ch/en/api/v1#server-groups
 	ServerGroups []string `json:"serverGroups,omitempty"`
+	// ServerGroupNames is a list of names of server groups in the zone of the machine to which the new server will be added.
+	// A missing server group is created as an anti-affinity group and tagged as owned by the provider.
+	// A name matching more than one server group in the zone is a terminal error.
+	// Server groups can be managed declaratively with the CloudscaleServerGroup resource.
+	// Names of CloudscaleServerGroups in the machine's namespace and zone are never created by the machine, creation waits until the CloudscaleServerGroup has a server group.
+	// +optional
+	ServerGroupNames []string `json:"serverGroupNames,omitempty"`
 	// Tags is a map of tags to apply to the machine.
 	Tags map[string]string `json:"tags"`
 	// Flavor is the flavor of the machine.
 	Flavor string `json:"flavor"`
+	// FlavorFallbacks is a list of flavors tried in order if the server can't be created with Flavor for capacity reasons.
+	// All flavors are tried in a zone before falling back to the next zone in ZoneFallbacks.
+	// Flavors exceeding the quota of the provider are skipped.
+	// The flavor the server was created with is recorded in the machine.openshift.io/instance-type label and the provider status.
+	// +optional
+	FlavorFallbacks []string `json:"flavorFallbacks,omitempty"`
 	// Image is the base image to use for the machine.
 	// For images provided by cloudscale: the image’s slug.
 	// For custom images: the image’s slug prefixed with custom: (e.g. custom:ubuntu-foo), or its UUID.
@@ -89,10 +181,36 @@ type Interface struct {
 	// NetworkUUID is the UUID of the network to attach the interface to.
 	// Can only be set if type is private.
 	// Must be compatible with Addresses.SubnetUUID if both are specified.
+	// Mutually exclusive with NetworkName and NetworkTags.
 	NetworkUUID string `json:"networkUUID"`
+	// NetworkName selects the network to attach the interface to by its name.
+	// Only networks in the zone of the machine are considered.
+	// Can be combined with NetworkTags. The selectors must match exactly one network.
+	// Can only be set if type is private.
+	// +optional
+	NetworkName string `json:"networkName,omitempty"`
+	// NetworkTags selects the network to attach the interface to by its tags.
+	// Only networks in the zone of the machine are considered.
+	// Can be combined with NetworkName. The selectors must match exactly one network.
+	// Can only be set if type is private.
+	// +optional
+	NetworkTags map[string]string `json:"networkTags,omitempty"`
 	// Addresses is an optional list of addresses to assign to the interface.
 	// Can only be set if type is private.
 	Addresses []Address `json:"addresses"`
+	// AddressesFromPools is an optional list of IP address pools to claim addresses from.
+	// An IPAddressClaim is created for each pool and the server is only created once all claims are bound.
+	// The claimed addresses are assigned to the interface in addition to Addresses.
+	// The claims are released when the machine is deleted.
+	// Can only be set if type is private.
+	// +optional
+	AddressesFromPools []machinev1beta1.AddressesFromPool `json:"addressesFromPools,omitempty"`
+	// AddressFromRange is an optional range of addresses to allocate an address from.
+	// The first address in the range not used by another Machine or by a server attached to the same network is assigned to the interface in addition to Addresses.
+	// The allocated address is recorded in the AllocatedAddressesAnnotation of the Machine.
+	// Can only be set if type is private.
+	// +optional
+	AddressFromRange *AddressRange `json:"addressFromRange,omitempty"`
 }
 
 // Address is an address to assign to a network interface.
@@ -101,7 +219,48 @@ type Address struct {
 	Address string `json:"address"`
 	// SubnetUUID is the UUID of the subnet to assign the address to.
 	// Must be compatible with Interface.NetworkUUID if both are specified.
+	// Mutually exclusive with SubnetCIDR and SubnetTags.
 	SubnetUUID string `json:"subnetUUID"`
+	// SubnetCIDR selects the subnet to assign the address to by its CIDR.
+	// Only subnets of the network of the interface are considered, if the network is specified.
+	// Can be combined with SubnetTags. The selectors must match exactly one subnet.
+	// +optional
+	SubnetCIDR string `json:"subnetCIDR,omitempty"`
+	// SubnetTags selects the subnet to assign the address to by its tags.
+	// Only subnets of the network of the interface are considered, if the network is specified.
+	// Can be combined with SubnetCIDR. The selectors must match exactly one subnet.
+	// +optional
+	SubnetTags map[string]string `json:"subnetTags,omitempty"`
+}
+
+// MachineZone is a zone of a multi-zone machine.
+type MachineZone struct {
+	// Zone is the name of the zone.
+	Zone string `json:"zone"`
+	// Interfaces replaces the Interfaces of the provider spec in this zone.
+	// Networks are zonal, so machines with private networks need interfaces for each zone.
+	// +optional
+	Interfaces []Interface `json:"interfaces,omitempty"`
+}
+
+// AddressRange is an inclusive range of IPv4 or IPv6 addresses.
+type AddressRange struct {
+	// Start is the first address of the range.
+	Start string `json:"start"`
+	// End is the last address of the range.
+	End string `json:"end"`
+}
+
+// InterfaceStatus is a network interface of the server with the network and subnets resolved to UUIDs.
+type InterfaceStatus struct {
+	// Type is the type of the interface.
+	Type InterfaceType `json:"type"`
+	// NetworkUUID is the UUID of the network the interface is attached to.
+	// +optional
+	NetworkUUID string `json:"networkUUID,omitempty"`
+	// SubnetUUIDs are the UUIDs of the subnets of the addresses of the interface, in the order of Interface.Addresses.
+	// +optional
+	SubnetUUIDs []string `json:"subnetUUIDs,omitempty"`
 }
 
 // CloudscaleMachineProviderStatus is the type that will be embedded in a Machine.Status.ProviderStatus field.
@@ -115,6 +274,33 @@ type CloudscaleMachineProviderStatus struct {
 	// Status is the status of the instance in Cloudscale.
 	// Can be "changing", "running" or "stopped".
 	Status string `json:"status,omitempty"`
+	// Zone is the zone of the instance in Cloudscale.
+	// +optional
+	Zone string `json:"zone,omitempty"`
+	// Flavor is the flavor of the instance in Cloudscale.
+	// +optional
+	Flavor string `json:"flavor,omitempty"`
+	// UserDataHash is the hex encoded SHA-256 hash of the user data the server was created with.
+	// +optional
+	UserDataHash string `json:"userDataHash,omitempty"`
+	// UserDataSecretResourceVersion is the resource version of the UserDataSecret the user data was rendered from.
+	// +optional
+	UserDataSecretResourceVersion string `json:"userDataSecretResourceVersion,omitempty"`
+	// UserDataSourcesVersion is a hash of the resource versions of the UserDataSecret, the secrets matching UserDataSecretSelector
+	// and the Jsonnet library objects the user data was last rendered from.
+	// The user data is only rendered again to check for drift if one of them changed.
+	// +optional
+	UserDataSourcesVersion string `json:"userDataSourcesVersion,omitempty"`
+	// Interfaces are the network interfaces the server was created with, with network and subnet selectors resolved to UUIDs.
+	// +optional
+	Interfaces []InterfaceStatus `json:"interfaces,omitempty"`
+	// InterfacesSpecHash is the hex encoded SHA-256 hash of the interfaces of the provider spec Interfaces were resolved from.
+	// The selectors of the provider spec are only resolved again if the interfaces of the provider spec changed.
+	// +optional
+	InterfacesSpecHash string `json:"interfacesSpecHash,omitempty"`
+	// RenderedUserDataSecret is a reference to the Secret containing the rendered user data if StoreRenderedUserData is set.
+	// +optional
+	RenderedUserDataSecret *corev1.LocalObjectReference `json:"renderedUserDataSecret,omitempty"`
 	// Conditions is a set of conditions associated with the Machine to indicate
 	// errors or other status
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
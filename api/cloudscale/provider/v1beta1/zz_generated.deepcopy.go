@@ -5,6 +5,7 @@
 package v1beta1
 
 import (
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -13,6 +14,13 @@ import (
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Address) DeepCopyInto(out *Address) {
 	*out = *in
+	if in.SubnetTags != nil {
+		in, out := &in.SubnetTags, &out.SubnetTags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Address.
@@ -25,6 +33,21 @@ func (in *Address) DeepCopy() *Address {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AddressRange) DeepCopyInto(out *AddressRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddressRange.
+func (in *AddressRange) DeepCopy() *AddressRange {
+	if in == nil {
+		return nil
+	}
+	out := new(AddressRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CloudscaleMachineProviderSpec) DeepCopyInto(out *CloudscaleMachineProviderSpec) {
 	*out = *in
@@ -45,11 +68,28 @@ func (in *CloudscaleMachineProviderSpec) DeepCopyInto(out *CloudscaleMachineProv
 		*out = new(v1.LocalObjectReference)
 		**out = **in
 	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]MachineZone, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ZoneFallbacks != nil {
+		in, out := &in.ZoneFallbacks, &out.ZoneFallbacks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.ServerGroups != nil {
 		in, out := &in.ServerGroups, &out.ServerGroups
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ServerGroupNames != nil {
+		in, out := &in.ServerGroupNames, &out.ServerGroupNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make(map[string]string, len(*in))
@@ -57,6 +97,11 @@ func (in *CloudscaleMachineProviderSpec) DeepCopyInto(out *CloudscaleMachineProv
 			(*out)[key] = val
 		}
 	}
+	if in.FlavorFallbacks != nil {
+		in, out := &in.FlavorFallbacks, &out.FlavorFallbacks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.RootVolumeTags != nil {
 		in, out := &in.RootVolumeTags, &out.RootVolumeTags
 		*out = make(map[string]string, len(*in))
@@ -105,6 +150,18 @@ func (in *CloudscaleMachineProviderSpec) DeepCopyObject() runtime.Object {
 func (in *CloudscaleMachineProviderStatus) DeepCopyInto(out *CloudscaleMachineProviderStatus) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]InterfaceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RenderedUserDataSecret != nil {
+		in, out := &in.RenderedUserDataSecret, &out.RenderedUserDataSecret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
@@ -127,11 +184,30 @@ func (in *CloudscaleMachineProviderStatus) DeepCopy() *CloudscaleMachineProvider
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Interface) DeepCopyInto(out *Interface) {
 	*out = *in
+	if in.NetworkTags != nil {
+		in, out := &in.NetworkTags, &out.NetworkTags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.Addresses != nil {
 		in, out := &in.Addresses, &out.Addresses
 		*out = make([]Address, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AddressesFromPools != nil {
+		in, out := &in.AddressesFromPools, &out.AddressesFromPools
+		*out = make([]machinev1beta1.AddressesFromPool, len(*in))
 		copy(*out, *in)
 	}
+	if in.AddressFromRange != nil {
+		in, out := &in.AddressFromRange, &out.AddressFromRange
+		*out = new(AddressRange)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Interface.
@@ -143,3 +219,45 @@ func (in *Interface) DeepCopy() *Interface {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceStatus) DeepCopyInto(out *InterfaceStatus) {
+	*out = *in
+	if in.SubnetUUIDs != nil {
+		in, out := &in.SubnetUUIDs, &out.SubnetUUIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceStatus.
+func (in *InterfaceStatus) DeepCopy() *InterfaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineZone) DeepCopyInto(out *MachineZone) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]Interface, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineZone.
+func (in *MachineZone) DeepCopy() *MachineZone {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineZone)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,87 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ServerGroupReadyCondition indicates whether the cloudscale server group of a CloudscaleServerGroup exists.
+	ServerGroupReadyCondition = "Ready"
+)
+
+// CloudscaleServerGroupSpec defines the desired state of a cloudscale server group.
+type CloudscaleServerGroupSpec struct {
+	// Name is the name of the server group in cloudscale.
+	// Machines reference the server group by this name in ServerGroupNames.
+	// Defaults to the name of the CloudscaleServerGroup.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Zone is the zone of the server group.
+	Zone string `json:"zone"`
+	// Tags are additional tags of the server group.
+	// They are merged into the tags of an existing server group, tags removed from the spec are not removed from the server group.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+	// TokenSecret is a reference to the secret with the cloudscale API token.
+	// If not set, the default token of the provider is used.
+	// +optional
+	TokenSecret *corev1.LocalObjectReference `json:"tokenSecret,omitempty"`
+}
+
+// CloudscaleServerGroupStatus defines the observed state of a cloudscale server group.
+type CloudscaleServerGroupStatus struct {
+	// UUID is the UUID of the server group in cloudscale.
+	// +optional
+	UUID string `json:"uuid,omitempty"`
+	// Members are the servers in the server group.
+	// +optional
+	Members []ServerGroupMember `json:"members,omitempty"`
+	// Conditions of the server group.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ServerGroupMember is a server in a server group.
+type ServerGroupMember struct {
+	// UUID is the UUID of the server.
+	UUID string `json:"uuid"`
+	// Machine is the name of the Machine of the server, if it is in the same namespace.
+	// +optional
+	Machine string `json:"machine,omitempty"`
+}
+
+// CloudscaleServerGroup manages a cloudscale anti-affinity server group.
+// The server group is deleted with the CloudscaleServerGroup once it has no members.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Zone",type="string",JSONPath=".spec.zone"
+// +kubebuilder:printcolumn:name="UUID",type="string",JSONPath=".status.uuid"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
+type CloudscaleServerGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CloudscaleServerGroupSpec   `json:"spec,omitempty"`
+	Status CloudscaleServerGroupStatus `json:"status,omitempty"`
+}
+
+// ServerGroupName returns the name of the server group in cloudscale.
+func (g *CloudscaleServerGroup) ServerGroupName() string {
+	if g.Spec.Name != "" {
+		return g.Spec.Name
+	}
+	return g.Name
+}
+
+// CloudscaleServerGroupList contains a list of CloudscaleServerGroup.
+// +kubebuilder:object:root=true
+type CloudscaleServerGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CloudscaleServerGroup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CloudscaleServerGroup{}, &CloudscaleServerGroupList{})
+}
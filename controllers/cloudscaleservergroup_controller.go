@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	cloudscalev1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine"
+)
+
+const (
+	// serverGroupFinalizer deletes the cloudscale server group of a CloudscaleServerGroup before the resource is removed.
+	serverGroupFinalizer = "machine-api-provider-cloudscale.appuio.io/server-group"
+	// serverGroupResyncInterval is the interval in which the membership of a server group is refreshed.
+	serverGroupResyncInterval = time.Minute
+
+	serverGroupReasonReady      = "Ready"
+	serverGroupReasonFailed     = "Failed"
+	serverGroupReasonHasMembers = "HasMembers"
+)
+
+// CloudscaleServerGroupReconciler reconciles CloudscaleServerGroup objects with cloudscale server groups.
+type CloudscaleServerGroupReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// DefaultToken returns the cloudscale API token used if the CloudscaleServerGroup has no TokenSecret.
+	DefaultToken             func() string
+	ServerGroupClientFactory func(token string) cloudscale.ServerGroupService
+}
+
+// Reconcile creates or adopts the cloudscale server group of a CloudscaleServerGroup and reports its members in the status.
+// Server groups created by the reconciler are deleted with the CloudscaleServerGroup once they have no members.
+func (r *CloudscaleServerGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var sg cloudscalev1beta1.CloudscaleServerGroup
+	if err := r.Get(ctx, req.NamespacedName, &sg); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	token, err := r.token(ctx, &sg)
+	if !sg.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, token, err, &sg)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	sgc := r.ServerGroupClientFactory(token)
+
+	if controllerutil.AddFinalizer(&sg, serverGroupFinalizer) {
+		if err := r.Update(ctx, &sg); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to add finalizer to CloudscaleServerGroup %q: %w", sg.Name, err)
+		}
+	}
+
+	orig := sg.DeepCopy()
+	group, err := r.ensureServerGroup(ctx, sgc, &sg)
+	if err != nil {
+		meta.SetStatusCondition(&sg.Status.Conditions, metav1.Condition{
+			Type:               cloudscalev1beta1.ServerGroupReadyCondition,
+			Status:             metav1.ConditionFalse,
+			Reason:             serverGroupReasonFailed,
+			Message:            err.Error(),
+			ObservedGeneration: sg.Generation,
+		})
+		return ctrl.Result{}, errors.Join(err, r.patchStatus(ctx, orig, &sg))
+	}
+
+	members, err := r.members(ctx, sg.Namespace, *group)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	sg.Status.UUID = group.UUID
+	sg.Status.Members = members
+	meta.SetStatusCondition(&sg.Status.Conditions, metav1.Condition{
+		Type:               cloudscalev1beta1.ServerGroupReadyCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             serverGroupReasonReady,
+		Message:            fmt.Sprintf("Server group %q has %d members", group.UUID, len(members)),
+		ObservedGeneration: sg.Generation,
+	})
+	return ctrl.Result{RequeueAfter: serverGroupResyncInterval}, r.patchStatus(ctx, orig, &sg)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CloudscaleServerGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cloudscalev1beta1.CloudscaleServerGroup{}).
+		Complete(r)
+}
+
+// ensureServerGroup returns the cloudscale server group of the CloudscaleServerGroup.
+// The server group is looked up by the UUID in the status, then by name and zone, and created if it does not exist.
+// The tags of the server group are updated to match the spec.
+func (r *CloudscaleServerGroupReconciler) ensureServerGroup(ctx context.Context, sgc cloudscale.ServerGroupService, sg *cloudscalev1beta1.CloudscaleServerGroup) (*cloudscale.ServerGroup, error) {
+	l := log.FromContext(ctx).WithName("CloudscaleServerGroupReconciler.ensureServerGroup")
+	name := sg.ServerGroupName()
+
+	group, err := r.lookupServerGroup(ctx, sgc, sg)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		tags := cloudscale.TagMap{machine.ManagedServerGroupTag: "true"}
+		maps.Copy(tags, sg.Spec.Tags)
+		l.Info("Creating server group", "name", name, "zone", sg.Spec.Zone)
+		group, err = sgc.Create(ctx, &cloudscale.ServerGroupRequest{
+			ZonalResourceRequest: cloudscale.ZonalResourceRequest{
+				Zone: sg.Spec.Zone,
+			},
+			TaggedResourceRequest: cloudscale.TaggedResourceRequest{
+				Tags: ptr.To(tags),
+			},
+			Name: name,
+			Type: "anti-affinity",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create server group %q: %w", name, err)
+		}
+		return group, nil
+	}
+
+	if group.Zone.Slug != sg.Spec.Zone {
+		return nil, fmt.Errorf("server group %q is in zone %q, the zone of a server group cannot be changed", group.UUID, group.Zone.Slug)
+	}
+
+	// The tags of the spec are merged into the existing tags, tags set by others such as the actuator are kept.
+	// The managed tag is kept as is, so adopted server groups are never deleted.
+	tags := cloudscale.TagMap{}
+	maps.Copy(tags, group.Tags)
+	maps.Copy(tags, sg.Spec.Tags)
+	if v, ok := group.Tags[machine.ManagedServerGroupTag]; ok {
+		tags[machine.ManagedServerGroupTag] = v
+	} else {
+		delete(tags, machine.ManagedServerGroupTag)
+	}
+	if group.Name != name || !maps.Equal(group.Tags, tags) {
+		if err := sgc.Update(ctx, group.UUID, &cloudscale.ServerGroupRequest{
+			TaggedResourceRequest: cloudscale.TaggedResourceRequest{
+				Tags: ptr.To(tags),
+			},
+			Name: name,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to update server group %q: %w", group.UUID, err)
+		}
+		group.Name = name
+		group.Tags = tags
+	}
+	return group, nil
+}
+
+// lookupServerGroup returns the server group with the UUID in the status or the only server group with the name and zone of the spec.
+// Returns nil if no server group exists.
+func (r *CloudscaleServerGroupReconciler) lookupServerGroup(ctx context.Context, sgc cloudscale.ServerGroupService, sg *cloudscalev1beta1.CloudscaleServerGroup) (*cloudscale.ServerGroup, error) {
+	if sg.Status.UUID != "" {
+		group, err := sgc.Get(ctx, sg.Status.UUID)
+		if err == nil {
+			return group, nil
+		}
+		if !isNotFound(err) {
+			return nil, fmt.Errorf("failed to get server group %q: %w", sg.Status.UUID, err)
+		}
+	}
+
+	groups, err := sgc.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list server groups: %w", err)
+	}
+	var matches []cloudscale.ServerGroup
+	for _, g := range groups {
+		if g.Zone.Slug == sg.Spec.Zone && g.Name == sg.ServerGroupName() {
+			matches = append(matches, g)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("%d server groups with name %q found in zone %q, expected at most one", len(matches), sg.ServerGroupName(), sg.Spec.Zone)
+	}
+}
+
+// reconcileDelete deletes the server group if it was created by the provider and has no members, then removes the finalizer.
+// The TokenSecret might be deleted before the CloudscaleServerGroup, for example if the namespace is deleted.
+// If the token can't be loaded, the default token is used. Without a default token, the server group is left in place and only the finalizer is removed.
+func (r *CloudscaleServerGroupReconciler) reconcileDelete(ctx context.Context, token string, tokenErr error, sg *cloudscalev1beta1.CloudscaleServerGroup) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(sg, serverGroupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	l := log.FromContext(ctx).WithName("CloudscaleServerGroupReconciler.reconcileDelete")
+
+	uuid := sg.Status.UUID
+	if tokenErr != nil {
+		token = r.DefaultToken()
+		if token == "" {
+			l.Info("Unable to load token and no default token configured, not deleting server group", "uuid", uuid, "error", tokenErr.Error())
+			uuid = ""
+		} else {
+			l.Info("Unable to load token, falling back to default token", "error", tokenErr.Error())
+		}
+	}
+
+	if uuid != "" {
+		sgc := r.ServerGroupClientFactory(token)
+		group, err := sgc.Get(ctx, uuid)
+		if err != nil && !isNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to get server group %q: %w", uuid, err)
+		}
+		if err == nil && group.Tags[machine.ManagedServerGroupTag] != "" {
+			if len(group.Servers) > 0 {
+				orig := sg.DeepCopy()
+				meta.SetStatusCondition(&sg.Status.Conditions, metav1.Condition{
+					Type:               cloudscalev1beta1.ServerGroupReadyCondition,
+					Status:             metav1.ConditionFalse,
+					Reason:             serverGroupReasonHasMembers,
+					Message:            fmt.Sprintf("Waiting for %d servers to leave the server group before deleting it", len(group.Servers)),
+					ObservedGeneration: sg.Generation,
+				})
+				return ctrl.Result{RequeueAfter: serverGroupResyncInterval}, r.patchStatus(ctx, orig, sg)
+			}
+			if err := sgc.Delete(ctx, group.UUID); err != nil && !isNotFound(err) {
+				return ctrl.Result{}, fmt.Errorf("failed to delete server group %q: %w", group.UUID, err)
+			}
+			l.Info("Deleted server group", "uuid", group.UUID)
+		}
+	}
+
+	controllerutil.RemoveFinalizer(sg, serverGroupFinalizer)
+	if err := r.Update(ctx, sg); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove finalizer from CloudscaleServerGroup %q: %w", sg.Name, err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// members returns the members of the server group.
+// Servers are mapped to the Machines in the namespace by the instance ID in their provider status.
+func (r *CloudscaleServerGroupReconciler) members(ctx context.Context, namespace string, group cloudscale.ServerGroup) ([]cloudscalev1beta1.ServerGroupMember, error) {
+	if len(group.Servers) == 0 {
+		return nil, nil
+	}
+
+	var machines machinev1beta1.MachineList
+	if err := r.List(ctx, &machines, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list machines: %w", err)
+	}
+	machineByUUID := make(map[string]string, len(machines.Items))
+	for _, m := range machines.Items {
+		status, err := csv1beta1.ProviderStatusFromRawExtension(m.Status.ProviderStatus)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get provider status from machine %q: %w", m.Name, err)
+		}
+		if status.InstanceID != "" {
+			machineByUUID[status.InstanceID] = m.Name
+		}
+	}
+
+	members := make([]cloudscalev1beta1.ServerGroupMember, 0, len(group.Servers))
+	for _, s := range group.Servers {
+		members = append(members, cloudscalev1beta1.ServerGroupMember{
+			UUID:    s.UUID,
+			Machine: machineByUUID[s.UUID],
+		})
+	}
+	return members, nil
+}
+
+// token returns the cloudscale API token from the TokenSecret of the CloudscaleServerGroup or the default token.
+func (r *CloudscaleServerGroupReconciler) token(ctx context.Context, sg *cloudscalev1beta1.CloudscaleServerGroup) (string, error) {
+	if sg.Spec.TokenSecret == nil {
+		return r.DefaultToken(), nil
+	}
+	var secret corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: sg.Namespace, Name: sg.Spec.TokenSecret.Name}, &secret); err != nil {
+		return "", fmt.Errorf("failed to get secret %q: %w", sg.Spec.TokenSecret.Name, err)
+	}
+	tb, ok := secret.Data[machine.TokenSecretKey]
+	if !ok {
+		return "", fmt.Errorf("token key %q not found in secret %q", machine.TokenSecretKey, sg.Spec.TokenSecret.Name)
+	}
+	return string(tb), nil
+}
+
+func (r *CloudscaleServerGroupReconciler) patchStatus(ctx context.Context, orig, sg *cloudscalev1beta1.CloudscaleServerGroup) error {
+	if err := r.Status().Patch(ctx, sg, client.MergeFrom(orig)); err != nil {
+		return fmt.Errorf("failed to patch status of CloudscaleServerGroup %q: %w", sg.Name, err)
+	}
+	return nil
+}
+
+// isNotFound returns true if the error is a cloudscale API not found error.
+func isNotFound(err error) bool {
+	var errResp *cloudscale.ErrorResponse
+	return errors.As(err, &errResp) && errResp.StatusCode == http.StatusNotFound
+}
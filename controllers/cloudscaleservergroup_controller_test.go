@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	cloudscalev1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine/csmock"
+)
+
+func Test_CloudscaleServerGroupReconciler_Reconcile(t *testing.T) {
+	const ns = "openshift-machine-api"
+
+	machineWithInstanceID := func(name, uuid string) *machinev1beta1.Machine {
+		status, err := csv1beta1.RawExtensionFromProviderStatus(&csv1beta1.CloudscaleMachineProviderStatus{InstanceID: uuid})
+		require.NoError(t, err)
+		return &machinev1beta1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Status:     machinev1beta1.MachineStatus{ProviderStatus: status},
+		}
+	}
+	rma1 := cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "rma1"}}
+
+	tcs := []struct {
+		name    string
+		group   *cloudscalev1beta1.CloudscaleServerGroup
+		apiMock func(*csmock.MockServerGroupService)
+
+		noDefaultToken bool
+
+		expectedResult ctrl.Result
+		expectDeleted  bool
+		expectedStatus *cloudscalev1beta1.CloudscaleServerGroupStatus
+		expectedReason string
+	}{
+		{
+			name: "creates missing server group",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns},
+				Spec: cloudscalev1beta1.CloudscaleServerGroupSpec{
+					Zone: "rma1",
+					Tags: map[string]string{"team": "platform"},
+				},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().List(gomock.Any()).Return([]cloudscale.ServerGroup{
+					{UUID: "other-zone-uuid", Name: "control-plane", ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "lpg1"}}},
+				}, nil)
+				sgs.EXPECT().Create(gomock.Any(), &cloudscale.ServerGroupRequest{
+					ZonalResourceRequest:  cloudscale.ZonalResourceRequest{Zone: "rma1"},
+					TaggedResourceRequest: cloudscale.TaggedResourceRequest{Tags: ptr.To(cloudscale.TagMap{machine.ManagedServerGroupTag: "true", "team": "platform"})},
+					Name:                  "control-plane",
+					Type:                  "anti-affinity",
+				}).Return(&cloudscale.ServerGroup{UUID: "sg-uuid", Name: "control-plane", ZonalResource: rma1}, nil)
+			},
+			expectedResult: ctrl.Result{RequeueAfter: serverGroupResyncInterval},
+			expectedStatus: &cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			expectedReason: serverGroupReasonReady,
+		},
+		{
+			name: "reports members and updates tags",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}},
+				Spec: cloudscalev1beta1.CloudscaleServerGroupSpec{
+					Name: "cp",
+					Zone: "rma1",
+					Tags: map[string]string{"team": "platform"},
+				},
+				Status: cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().Get(gomock.Any(), "sg-uuid").Return(&cloudscale.ServerGroup{
+					UUID:           "sg-uuid",
+					Name:           "cp",
+					ZonalResource:  rma1,
+					TaggedResource: cloudscale.TaggedResource{Tags: cloudscale.TagMap{machine.ManagedServerGroupTag: "true", "team": "storage"}},
+					Servers:        []cloudscale.ServerStub{{UUID: "server-1"}, {UUID: "server-unknown"}},
+				}, nil)
+				sgs.EXPECT().Update(gomock.Any(), "sg-uuid", &cloudscale.ServerGroupRequest{
+					TaggedResourceRequest: cloudscale.TaggedResourceRequest{Tags: ptr.To(cloudscale.TagMap{machine.ManagedServerGroupTag: "true", "team": "platform"})},
+					Name:                  "cp",
+				}).Return(nil)
+			},
+			expectedResult: ctrl.Result{RequeueAfter: serverGroupResyncInterval},
+			expectedStatus: &cloudscalev1beta1.CloudscaleServerGroupStatus{
+				UUID: "sg-uuid",
+				Members: []cloudscalev1beta1.ServerGroupMember{
+					{UUID: "server-1", Machine: "app-1"},
+					{UUID: "server-unknown"},
+				},
+			},
+			expectedReason: serverGroupReasonReady,
+		},
+		{
+			name: "adopts server group and keeps its tags",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}},
+				Spec: cloudscalev1beta1.CloudscaleServerGroupSpec{
+					Zone: "rma1",
+					Tags: map[string]string{"team": "platform", machine.ManagedServerGroupTag: "true"},
+				},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().List(gomock.Any()).Return([]cloudscale.ServerGroup{{
+					UUID:          "sg-uuid",
+					Name:          "control-plane",
+					ZonalResource: rma1,
+					TaggedResource: cloudscale.TaggedResource{Tags: cloudscale.TagMap{
+						"machine-api-provider-cloudscale_appuio_io_antiAffinityKey": "control-plane",
+					}},
+				}}, nil)
+				sgs.EXPECT().Update(gomock.Any(), "sg-uuid", &cloudscale.ServerGroupRequest{
+					TaggedResourceRequest: cloudscale.TaggedResourceRequest{Tags: ptr.To(cloudscale.TagMap{
+						"machine-api-provider-cloudscale_appuio_io_antiAffinityKey": "control-plane",
+						"team": "platform",
+					})},
+					Name: "control-plane",
+				}).Return(nil)
+			},
+			expectedResult: ctrl.Result{RequeueAfter: serverGroupResyncInterval},
+			expectedStatus: &cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			expectedReason: serverGroupReasonReady,
+		},
+		{
+			name: "ambiguous name",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}},
+				Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: "rma1"},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().List(gomock.Any()).Return([]cloudscale.ServerGroup{
+					{UUID: "sg-1", Name: "control-plane", ZonalResource: rma1},
+					{UUID: "sg-2", Name: "control-plane", ZonalResource: rma1},
+				}, nil)
+			},
+			expectedStatus: &cloudscalev1beta1.CloudscaleServerGroupStatus{},
+			expectedReason: serverGroupReasonFailed,
+		},
+		{
+			name: "waits for members before deleting",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}, DeletionTimestamp: ptr.To(metav1.Now())},
+				Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: "rma1"},
+				Status:     cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().Get(gomock.Any(), "sg-uuid").Return(&cloudscale.ServerGroup{
+					UUID:           "sg-uuid",
+					TaggedResource: cloudscale.TaggedResource{Tags: cloudscale.TagMap{machine.ManagedServerGroupTag: "true"}},
+					Servers:        []cloudscale.ServerStub{{UUID: "server-1"}},
+				}, nil)
+			},
+			expectedResult: ctrl.Result{RequeueAfter: serverGroupResyncInterval},
+			expectedStatus: &cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			expectedReason: serverGroupReasonHasMembers,
+		},
+		{
+			name: "deletes managed server group",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}, DeletionTimestamp: ptr.To(metav1.Now())},
+				Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: "rma1"},
+				Status:     cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().Get(gomock.Any(), "sg-uuid").Return(&cloudscale.ServerGroup{
+					UUID:           "sg-uuid",
+					TaggedResource: cloudscale.TaggedResource{Tags: cloudscale.TagMap{machine.ManagedServerGroupTag: "true"}},
+				}, nil)
+				sgs.EXPECT().Delete(gomock.Any(), "sg-uuid").Return(nil)
+			},
+			expectDeleted: true,
+		},
+		{
+			name: "keeps adopted server group",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}, DeletionTimestamp: ptr.To(metav1.Now())},
+				Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: "rma1"},
+				Status:     cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().Get(gomock.Any(), "sg-uuid").Return(&cloudscale.ServerGroup{UUID: "sg-uuid"}, nil)
+			},
+			expectDeleted: true,
+		},
+		{
+			name: "falls back to default token if token secret is deleted",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}, DeletionTimestamp: ptr.To(metav1.Now())},
+				Spec: cloudscalev1beta1.CloudscaleServerGroupSpec{
+					Zone:        "rma1",
+					TokenSecret: &corev1.LocalObjectReference{Name: "deleted-token"},
+				},
+				Status: cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			},
+			apiMock: func(sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().Get(gomock.Any(), "sg-uuid").Return(&cloudscale.ServerGroup{
+					UUID:           "sg-uuid",
+					TaggedResource: cloudscale.TaggedResource{Tags: cloudscale.TagMap{machine.ManagedServerGroupTag: "true"}},
+				}, nil)
+				sgs.EXPECT().Delete(gomock.Any(), "sg-uuid").Return(nil)
+			},
+			expectDeleted: true,
+		},
+		{
+			name: "removes finalizer if token secret is deleted and no default token is configured",
+			group: &cloudscalev1beta1.CloudscaleServerGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: ns, Finalizers: []string{serverGroupFinalizer}, DeletionTimestamp: ptr.To(metav1.Now())},
+				Spec: cloudscalev1beta1.CloudscaleServerGroupSpec{
+					Zone:        "rma1",
+					TokenSecret: &corev1.LocalObjectReference{Name: "deleted-token"},
+				},
+				Status: cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "sg-uuid"},
+			},
+			apiMock:        func(sgs *csmock.MockServerGroupService) {},
+			noDefaultToken: true,
+			expectDeleted:  true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, machinev1beta1.AddToScheme(scheme))
+			require.NoError(t, cloudscalev1beta1.AddToScheme(scheme))
+
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithRuntimeObjects(tc.group, machineWithInstanceID("app-1", "server-1")).
+				WithStatusSubresource(&cloudscalev1beta1.CloudscaleServerGroup{}).
+				Build()
+
+			sgs := csmock.NewMockServerGroupService(mockCtrl)
+			tc.apiMock(sgs)
+
+			defaultToken := "default-token"
+			if tc.noDefaultToken {
+				defaultToken = ""
+			}
+			subject := &CloudscaleServerGroupReconciler{
+				Client:       c,
+				Scheme:       scheme,
+				DefaultToken: func() string { return defaultToken },
+				ServerGroupClientFactory: func(token string) cloudscale.ServerGroupService {
+					assert.Equal(t, "default-token", token)
+					return sgs
+				},
+			}
+
+			res, err := subject.Reconcile(t.Context(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(tc.group)})
+			if tc.expectedReason == serverGroupReasonFailed {
+				require.ErrorContains(t, err, "2 server groups")
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedResult, res)
+
+			var updated cloudscalev1beta1.CloudscaleServerGroup
+			err = c.Get(t.Context(), client.ObjectKeyFromObject(tc.group), &updated)
+			if tc.expectDeleted {
+				require.True(t, apierrors.IsNotFound(err), "CloudscaleServerGroup should be deleted, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, updated.Finalizers, serverGroupFinalizer)
+			assert.Equal(t, tc.expectedStatus.UUID, updated.Status.UUID)
+			assert.Equal(t, tc.expectedStatus.Members, updated.Status.Members)
+			cond := meta.FindStatusCondition(updated.Status.Conditions, cloudscalev1beta1.ServerGroupReadyCondition)
+			require.NotNil(t, cond)
+			assert.Equal(t, tc.expectedReason, cond.Reason)
+		})
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/jsonnetlimits"
 )
 
 const (
@@ -35,6 +37,9 @@ type MachineAPIControllersReconciler struct {
 	Scheme *runtime.Scheme
 
 	Namespace string
+
+	// JsonnetLimits are the limits for evaluating the deployment template.
+	JsonnetLimits jsonnetlimits.Limits
 }
 
 func (r *MachineAPIControllersReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -72,12 +77,12 @@ func (r *MachineAPIControllersReconciler) Reconcile(ctx context.Context, req ctr
 		return ctrl.Result{}, fmt.Errorf("failed to check for original upstream deployment %s: %w", originalUpstreamDeploymentName, err)
 	}
 
-	vm, err := jsonnetVMWithContext(images)
+	vm, jctx, err := jsonnetVMWithContext(images)
 	if err != nil {
 		return ctrl.Result{}, fmt.Errorf("failed to create jsonnet VM: %w", err)
 	}
 
-	ud, err := vm.EvaluateAnonymousSnippet("controllers_deployment.jsonnet", deploymentTemplate)
+	ud, err := r.JsonnetLimits.EvaluateAnonymousSnippet(ctx, vm, "controllers_deployment.jsonnet", deploymentTemplate, jctx)
 	if err != nil {
 		return ctrl.Result{}, fmt.Errorf("failed to evaluate jsonnet: %w", err)
 	}
@@ -110,16 +115,17 @@ func (r *MachineAPIControllersReconciler) SetupWithManager(mgr ctrl.Manager) err
 		Complete(r)
 }
 
-func jsonnetVMWithContext(images map[string]string) (*jsonnet.VM, error) {
+// jsonnetVMWithContext returns a Jsonnet VM with the images available as std.extVar('context') and the context as JSON.
+func jsonnetVMWithContext(images map[string]string) (*jsonnet.VM, string, error) {
 	jcr, err := json.Marshal(map[string]any{
 		"images": images,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal jsonnet context: %w", err)
+		return nil, "", fmt.Errorf("unable to marshal jsonnet context: %w", err)
 	}
 	jvm := jsonnet.MakeVM()
 	jvm.ExtCode("context", string(jcr))
 	// Don't allow imports
 	jvm.Importer(&jsonnet.MemoryImporter{})
-	return jvm, nil
+	return jvm, string(jcr), nil
 }
@@ -10,6 +10,8 @@ import (
 
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -35,9 +37,13 @@ const (
 
 	gpuKeyValue = "0"
 	arch        = "kubernetes.io/arch=amd64"
+
+	// outdatedUserDataKey is the number of machines of the MachineSet whose user data differs from the user data their server was created with.
+	outdatedUserDataKey = "machine-api-provider-cloudscale.appuio.io/outdated-user-data-machines"
 )
 
 // Reconcile reacts to MachineSet changes and updates the annotations used by the OpenShift autoscaler.
+// It also counts the machines of the MachineSet running with outdated user data.
 // GPU is always set to 0, as cloudscale does not provide GPU instances.
 // The architecture label is always set to amd64.
 func (r *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -84,6 +90,12 @@ func (r *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		arch,
 		machineSet.Annotations[labelsKey])
 
+	outdated, err := r.countOutdatedUserDataMachines(ctx, &machineSet)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	machineSet.Annotations[outdatedUserDataKey] = strconv.Itoa(outdated)
+
 	if equality.Semantic.DeepEqual(origSet.Annotations, machineSet.Annotations) {
 		return ctrl.Result{}, nil
 	}
@@ -99,9 +111,33 @@ func (r *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 func (r *MachineSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&machinev1beta1.MachineSet{}).
+		Owns(&machinev1beta1.Machine{}).
 		Complete(r)
 }
 
+// countOutdatedUserDataMachines returns the number of machines controlled by the MachineSet with a false UserDataUpToDate condition.
+func (r *MachineSetReconciler) countOutdatedUserDataMachines(ctx context.Context, machineSet *machinev1beta1.MachineSet) (int, error) {
+	var machines machinev1beta1.MachineList
+	if err := r.List(ctx, &machines, client.InNamespace(machineSet.Namespace)); err != nil {
+		return 0, fmt.Errorf("failed to list machines: %w", err)
+	}
+
+	count := 0
+	for _, m := range machines.Items {
+		if !metav1.IsControlledBy(&m, machineSet) {
+			continue
+		}
+		status, err := csv1beta1.ProviderStatusFromRawExtension(m.Status.ProviderStatus)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get provider status from machine %q: %w", m.Name, err)
+		}
+		if meta.IsStatusConditionFalse(status.Conditions, csv1beta1.UserDataUpToDateCondition) {
+			count++
+		}
+	}
+	return count, nil
+}
+
 type cloudscaleFlavor struct {
 	Type  string
 	CPU   int
@@ -67,6 +67,82 @@ func Test_MachineSetReconciler_Reconcile(t *testing.T) {
 	assert.Equal(t, "50Gi", updated.Annotations[diskKey])
 }
 
+func Test_MachineSetReconciler_Reconcile_OutdatedUserData(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, machinev1beta1.AddToScheme(scheme))
+
+	ms := &machinev1beta1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "machineset1",
+			Namespace: "default",
+			UID:       "machineset1-uid",
+		},
+	}
+	setMachineSetProviderData(ms, &csv1beta1.CloudscaleMachineProviderSpec{
+		Flavor:           "plus-4-2",
+		RootVolumeSizeGB: 50,
+	})
+	otherMs := &machinev1beta1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "machineset2",
+			Namespace: "default",
+			UID:       "machineset2-uid",
+		},
+	}
+
+	newMachine := func(name string, owner *machinev1beta1.MachineSet, upToDate metav1.ConditionStatus) *machinev1beta1.Machine {
+		m := &machinev1beta1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(owner, machinev1beta1.GroupVersion.WithKind("MachineSet")),
+				},
+			},
+		}
+		status := &csv1beta1.CloudscaleMachineProviderStatus{}
+		if upToDate != "" {
+			status.Conditions = []metav1.Condition{{
+				Type:   csv1beta1.UserDataUpToDateCondition,
+				Status: upToDate,
+				Reason: "Test",
+			}}
+		}
+		raw, err := csv1beta1.RawExtensionFromProviderStatus(status)
+		require.NoError(t, err)
+		m.Status.ProviderStatus = raw
+		return m
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRuntimeObjects(
+			ms,
+			newMachine("outdated-1", ms, metav1.ConditionFalse),
+			newMachine("outdated-2", ms, metav1.ConditionFalse),
+			newMachine("up-to-date", ms, metav1.ConditionTrue),
+			newMachine("unknown", ms, ""),
+			newMachine("other-outdated", otherMs, metav1.ConditionFalse),
+		).
+		Build()
+
+	subject := &MachineSetReconciler{
+		Client: c,
+		Scheme: scheme,
+	}
+
+	_, err := subject.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ms)})
+	require.NoError(t, err)
+	updated := &machinev1beta1.MachineSet{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(ms), updated))
+	assert.Equal(t, "2", updated.Annotations[outdatedUserDataKey])
+}
+
 func setMachineSetProviderData(machine *machinev1beta1.MachineSet, providerData *csv1beta1.CloudscaleMachineProviderSpec) {
 	machine.Spec.Template.Spec.ProviderSpec.Value = &runtime.RawExtension{
 		Raw: []byte(fmt.Sprintf(`{"flavor": "%s", "rootVolumeSizeGB": %d}`, providerData.Flavor, providerData.RootVolumeSizeGB)),
@@ -17,11 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
@@ -30,6 +31,8 @@ import (
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	"github.com/openshift/library-go/pkg/features"
 	capimachine "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apiserver/pkg/util/feature"
@@ -38,13 +41,18 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/component-base/featuregate"
 	"k8s.io/utils/ptr"
+	ipamv1beta1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
+	cloudscalev1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/v1beta1"
 	"github.com/appuio/machine-api-provider-cloudscale/controllers"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/csclient"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/jsonnetlimits"
 	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine"
 )
 
@@ -57,12 +65,14 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(configv1.AddToScheme(scheme))
 	utilruntime.Must(machinev1beta1.AddToScheme(scheme))
+	utilruntime.Must(ipamv1beta1.AddToScheme(scheme))
+	utilruntime.Must(cloudscalev1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var target string
-	flag.StringVar(&target, "target", "manager", "The target mode of this binary. Valid values are 'manager', 'machine-api-controllers-manager', and 'termination-handler'.")
+	flag.StringVar(&target, "target", "manager", "The target mode of this binary. Valid values are 'manager', 'machine-api-controllers-manager', 'termination-handler', and 'render-userdata'.")
 
 	var metricsAddr string
 	var enableLeaderElection bool
@@ -74,7 +84,44 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 
 	var watchNamespace string
-	flag.StringVar(&watchNamespace, "namespace", "", "Namespace that the controller watches to reconcile machine-api objects. If unspecified, the controller watches for machine-api objects across all namespaces.")
+	flag.StringVar(&watchNamespace, "namespace", "", "Namespace that the controller watches to reconcile machine-api objects. If unspecified, the controller watches for machine-api objects across all namespaces. Secrets and ConfigMaps are cached in the same namespaces.")
+
+	var tokenFile string
+	flag.StringVar(&tokenFile, "cloudscale-token-file", "", "File containing the default cloudscale API token. The file is watched for changes. If unspecified, the token is read from the CLOUDSCALE_API_TOKEN environment variable.")
+
+	var httpConfig csclient.HTTPConfig
+	flag.StringVar(&httpConfig.BaseURL, "cloudscale-api-url", "", "Base URL of the cloudscale API. If unspecified, the CLOUDSCALE_API_URL environment variable or the public cloudscale API is used.")
+	flag.StringVar(&httpConfig.ProxyURL, "cloudscale-proxy-url", "", "URL of the HTTP(S) proxy used to connect to the cloudscale API. If unspecified, the HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables are used.")
+	flag.StringVar(&httpConfig.CABundleFile, "cloudscale-ca-bundle-file", "", "File with PEM encoded CA certificates trusted in addition to the system CAs when connecting to the cloudscale API, e.g. mounted from the appuio-machine-api-ca-bundle ConfigMap.")
+	flag.DurationVar(&httpConfig.Timeout, "cloudscale-request-timeout", csclient.DefaultRequestTimeout, "Timeout for a single request to the cloudscale API. Zero means no timeout.")
+
+	var actuatorParams machine.ActuatorParams
+	flag.IntVar(&actuatorParams.MaxUserDataSize, "max-user-data-size", machine.DefaultMaxUserDataSize, "Maximum size in bytes of the rendered user data sent to the cloudscale API. Larger user data is compressed if allowed by the provider spec or rejected.")
+
+	flag.IntVar(&actuatorParams.JsonnetLimits.MaxStack, "jsonnet-max-stack", jsonnetlimits.DefaultMaxStack, "Maximum number of stack frames when evaluating Jsonnet templates.")
+	flag.DurationVar(&actuatorParams.JsonnetLimits.Timeout, "jsonnet-timeout", jsonnetlimits.DefaultTimeout, "Maximum duration of the evaluation of a Jsonnet template.")
+	flag.IntVar(&actuatorParams.JsonnetLimits.MaxOutputSize, "jsonnet-max-output-size", jsonnetlimits.DefaultMaxOutputSize, "Maximum size in bytes of the output of a Jsonnet template. The size is checked after the evaluation, the memory used during the evaluation is only bounded by --jsonnet-timeout and --jsonnet-max-stack.")
+
+	flag.StringVar(&actuatorParams.UserDataSecretPolicy.AllowedLabelPrefix, "user-data-secret-allowed-label-prefix", "", "Label key or label key prefix secrets must carry to be passed to the user data by UserDataSecretSelector. If unspecified, no label is required.")
+	flag.Func("user-data-secret-denied-types", "Comma separated list of secret types never passed to the user data by UserDataSecretSelector, e.g. kubernetes.io/service-account-token,kubernetes.io/dockerconfigjson.", func(v string) error {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				actuatorParams.UserDataSecretPolicy.DeniedTypes = append(actuatorParams.UserDataSecretPolicy.DeniedTypes, corev1.SecretType(t))
+			}
+		}
+		return nil
+	})
+
+	flag.BoolVar(&actuatorParams.ReconcileInterfaces, "reconcile-interfaces", false, "Attach and detach private networks of existing servers if the interfaces of the provider spec change. If unspecified, interface changes only apply to new machines.")
+
+	flag.IntVar(&actuatorParams.Quota.VCPUs, "quota-vcpus", 0, "Maximum number of vCPUs of all servers of a cloudscale project. Servers exceeding the quota are not created. Zero disables the check.")
+	flag.IntVar(&actuatorParams.Quota.MemoryGB, "quota-memory-gb", 0, "Maximum memory in GB of all servers of a cloudscale project. Servers exceeding the quota are not created. Zero disables the check.")
+	flag.IntVar(&actuatorParams.Quota.SSDVolumeGB, "quota-ssd-volume-gb", 0, "Maximum size in GB of all SSD volumes of a cloudscale project. Servers exceeding the quota are not created. Zero disables the check.")
+	flag.DurationVar(&actuatorParams.Quota.CacheDuration, "quota-cache-duration", machine.DefaultQuotaCacheDuration, "Duration the resource usage of a cloudscale project is cached for the quota check.")
+
+	var renderManifests, renderMachine string
+	flag.StringVar(&renderManifests, "render-manifests", "", "Comma separated list of manifest files with the Machine or MachineSet and the secrets used to render the user data. - reads from stdin. If unspecified, the objects are read from the cluster configured by the kubeconfig. Only used by the 'render-userdata' target.")
+	flag.StringVar(&renderMachine, "render-machine", "", "Name of the Machine or MachineSet to render the user data for. The namespace is taken from --namespace. Only used by the 'render-userdata' target.")
 
 	opts := zap.Options{
 		Development: true,
@@ -95,18 +142,20 @@ func main() {
 
 	switch target {
 	case "manager":
-		runManager(metricsAddr, probeAddr, watchNamespace, enableLeaderElection, featureGate)
+		runManager(metricsAddr, probeAddr, watchNamespace, tokenFile, httpConfig, actuatorParams, enableLeaderElection, featureGate)
 	case "termination-handler":
 		runTerminationHandler()
+	case "render-userdata":
+		runRenderUserData(renderManifests, renderMachine, watchNamespace, actuatorParams)
 	case "machine-api-controllers-manager":
-		runMachineAPIControllersManager(metricsAddr, probeAddr, watchNamespace, enableLeaderElection)
+		runMachineAPIControllersManager(metricsAddr, probeAddr, watchNamespace, actuatorParams.JsonnetLimits, enableLeaderElection)
 	default:
 		setupLog.Error(nil, "invalid target", "target", target)
 		os.Exit(1)
 	}
 }
 
-func runManager(metricsAddr, probeAddr, watchNamespace string, enableLeaderElection bool, featureGate featuregate.MutableVersionedFeatureGate) {
+func runManager(metricsAddr, probeAddr, watchNamespace, tokenFile string, httpConfig csclient.HTTPConfig, actuatorParams machine.ActuatorParams, enableLeaderElection bool, featureGate featuregate.MutableVersionedFeatureGate) {
 	opts := ctrl.Options{
 		Scheme: scheme,
 		Metrics: server.Options{
@@ -140,6 +189,8 @@ func runManager(metricsAddr, probeAddr, watchNamespace string, enableLeaderElect
 		}
 		setupLog.Info("Watching machine-api objects only given namespace for reconciliation.", "namespace", watchNamespace)
 	}
+	// Secrets and ConfigMaps are read from the namespaces of the machines, so they are cached in the same namespaces as the machines.
+	// Use --namespace to avoid caching every Secret and ConfigMap in the cluster.
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), opts)
 	if err != nil {
@@ -160,30 +211,84 @@ func runManager(metricsAddr, probeAddr, watchNamespace string, enableLeaderElect
 	if v, ok := debug.ReadBuildInfo(); ok {
 		versionString = fmt.Sprintf("%s (%s)", v.Main.Version, v.GoVersion)
 	}
-	userAgent := "machine-api-provider-cloudscale.appuio.io/" + versionString
+	actuatorParams.ProviderVersion = providerVersion()
+	httpConfig.UserAgent = "machine-api-provider-cloudscale.appuio.io/" + versionString
 
-	newClient := func(token string) *cloudscale.Client {
-		cs := cloudscale.NewClient(http.DefaultClient)
-		cs.UserAgent = userAgent
-		cs.AuthToken = token
-		return cs
+	newClient, err := httpConfig.NewClientFunc()
+	if err != nil {
+		setupLog.Error(err, "unable to configure cloudscale API client")
+		os.Exit(1)
+	}
+	clients := csclient.NewCache(newClient)
+	// Evict the clients of rotated or deleted token secrets.
+	secretInformer, err := mgr.GetCache().GetInformer(context.Background(), &corev1.Secret{})
+	if err != nil {
+		setupLog.Error(err, "unable to get secret informer")
+		os.Exit(1)
+	}
+	if _, err := secretInformer.AddEventHandler(clients.SecretEventHandler(machine.TokenSecretKey)); err != nil {
+		setupLog.Error(err, "unable to add secret event handler")
+		os.Exit(1)
 	}
 
-	machineActuator := machine.NewActuator(machine.ActuatorParams{
-		K8sClient: mgr.GetClient(),
-
-		DefaultCloudscaleAPIToken: os.Getenv("CLOUDSCALE_API_TOKEN"),
+	defaultToken := os.Getenv("CLOUDSCALE_API_TOKEN")
+	var tf *csclient.TokenFile
+	if tokenFile != "" {
+		tf, err = csclient.NewTokenFile(tokenFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load default token")
+			os.Exit(1)
+		}
+		defaultToken = tf.Token()
+	}
 
-		ServerClientFactory: func(token string) cloudscale.ServerService {
-			return newClient(token).Servers
-		},
-		ServerGroupClientFactory: func(token string) cloudscale.ServerGroupService {
-			return newClient(token).ServerGroups
-		},
-		VolumeClientFactory: func(token string) cloudscale.VolumeService {
-			return newClient(token).Volumes
-		},
-	})
+	actuatorParams.K8sClient = mgr.GetClient()
+	actuatorParams.EventRecorder = mgr.GetEventRecorderFor("cloudscale-controller")
+	actuatorParams.DefaultCloudscaleAPIToken = defaultToken
+	actuatorParams.TokenValidator = clients.Validate
+	actuatorParams.ServerClientFactory = func(token string) cloudscale.ServerService {
+		return clients.Client(token).Servers
+	}
+	actuatorParams.ServerGroupClientFactory = func(token string) cloudscale.ServerGroupService {
+		return clients.Client(token).ServerGroups
+	}
+	actuatorParams.VolumeClientFactory = func(token string) cloudscale.VolumeService {
+		return clients.Client(token).Volumes
+	}
+	actuatorParams.NetworkClientFactory = func(token string) cloudscale.NetworkService {
+		return clients.Client(token).Networks
+	}
+	actuatorParams.SubnetClientFactory = func(token string) cloudscale.SubnetService {
+		return clients.Client(token).Subnets
+	}
+	machineActuator := machine.NewActuator(actuatorParams)
+
+	defaultTokenFunc := func() string { return defaultToken }
+	if tf != nil {
+		defaultTokenFunc = tf.Token
+		prevToken := defaultToken
+		tf.OnChange(func(token string) {
+			machineActuator.SetDefaultCloudscaleAPIToken(token)
+			clients.Evict(prevToken)
+			prevToken = token
+		})
+		if err := mgr.Add(tf); err != nil {
+			setupLog.Error(err, "unable to watch default token file")
+			os.Exit(1)
+		}
+		if err := csclient.RegisterDefaultTokenMetrics(metrics.Registry, clients, tf); err != nil {
+			setupLog.Error(err, "unable to register default token metrics")
+			os.Exit(1)
+		}
+	}
+	// Machines with a TokenSecret work without a default token, a token is only required if the token file is configured.
+	// The token file might be filled in after startup.
+	apiCheck := csclient.NewAPICheck(clients, defaultTokenFunc)
+	apiCheck.RequireToken = tf != nil
+	if err := mgr.AddReadyzCheck("cloudscale-api", apiCheck.Check); err != nil {
+		setupLog.Error(err, "unable to set up cloudscale API ready check")
+		os.Exit(1)
+	}
 
 	if err := capimachine.AddWithActuator(mgr, machineActuator, featureGate); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Machine")
@@ -198,17 +303,41 @@ func runManager(metricsAddr, probeAddr, watchNamespace string, enableLeaderElect
 		os.Exit(1)
 	}
 
+	// The CloudscaleServerGroup CRD is optional, the controller would fail to start without it.
+	sggvk := cloudscalev1beta1.GroupVersion.WithKind("CloudscaleServerGroup")
+	if _, err := mgr.GetRESTMapper().RESTMapping(sggvk.GroupKind(), sggvk.Version); meta.IsNoMatchError(err) {
+		setupLog.Info("CloudscaleServerGroup CRD not installed, skipping controller")
+	} else if err := (&controllers.CloudscaleServerGroupReconciler{
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		DefaultToken: defaultTokenFunc,
+		ServerGroupClientFactory: func(token string) cloudscale.ServerGroupService {
+			return clients.Client(token).ServerGroups
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CloudscaleServerGroup")
+		os.Exit(1)
+	}
+
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
 
+// providerVersion returns the module version of the binary.
+func providerVersion() string {
+	if v, ok := debug.ReadBuildInfo(); ok {
+		return v.Main.Version
+	}
+	return "unknown"
+}
+
 func runTerminationHandler() {
 	panic("not implemented")
 }
 
-func runMachineAPIControllersManager(metricsAddr, probeAddr, watchNamespace string, enableLeaderElection bool) {
+func runMachineAPIControllersManager(metricsAddr, probeAddr, watchNamespace string, jsonnetLimits jsonnetlimits.Limits, enableLeaderElection bool) {
 	if watchNamespace == "" {
 		setupLog.Error(nil, "namespace must be set for the machine-api-controllers manager")
 		os.Exit(1)
@@ -252,7 +381,8 @@ func runMachineAPIControllersManager(metricsAddr, probeAddr, watchNamespace stri
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 
-		Namespace: watchNamespace,
+		Namespace:     watchNamespace,
+		JsonnetLimits: jsonnetLimits,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "UpstreamDeployment")
 		os.Exit(1)
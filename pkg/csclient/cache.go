@@ -0,0 +1,172 @@
+// Package csclient manages the cloudscale API clients used by the machine actuator.
+package csclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// ErrInvalidToken is returned by Cache.Validate if the cloudscale API rejected the token.
+var ErrInvalidToken = errors.New("cloudscale API token is invalid")
+
+const (
+	// DefaultInvalidTokenRetryInterval is the default interval after which a token that was rejected by the API is validated again.
+	DefaultInvalidTokenRetryInterval = time.Minute
+	// DefaultValidTokenRecheckInterval is the default interval after which a token that was accepted by the API is validated again.
+	DefaultValidTokenRecheckInterval = 10 * time.Minute
+)
+
+// Cache caches one cloudscale API client per token.
+// Reusing the clients allows them to share HTTP connections.
+// Clients of rotated or deleted token secrets are evicted from the cache, see SecretEventHandler.
+type Cache struct {
+	newClient func(token string) *cloudscale.Client
+
+	// InvalidTokenRetryInterval is the interval after which a token that was rejected by the API is validated again.
+	InvalidTokenRetryInterval time.Duration
+	// ValidTokenRecheckInterval is the interval after which a token that was accepted by the API is validated again.
+	// Detects tokens revoked in the cloudscale control panel instead of being rotated in the token secret.
+	ValidTokenRecheckInterval time.Duration
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	client *cloudscale.Client
+
+	validated     bool
+	validatedAt   time.Time
+	validationErr error
+}
+
+// NewCache returns a new client cache using the given function to create clients for tokens not yet in the cache.
+func NewCache(newClient func(token string) *cloudscale.Client) *Cache {
+	return &Cache{
+		newClient: newClient,
+
+		InvalidTokenRetryInterval: DefaultInvalidTokenRetryInterval,
+		ValidTokenRecheckInterval: DefaultValidTokenRecheckInterval,
+
+		now:     time.Now,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// Client returns the cached client for the given token or creates a new one.
+func (c *Cache) Client(token string) *cloudscale.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entry(token).client
+}
+
+// Evict removes the client and the validation result for the given token from the cache.
+func (c *Cache) Evict(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, token)
+}
+
+// Validate checks the token against the cloudscale API by listing the regions.
+// Valid tokens are validated again after ValidTokenRecheckInterval, tokens rejected by the API after InvalidTokenRetryInterval.
+// Returns an error wrapping ErrInvalidToken if the API rejected the token.
+// Other errors, such as the API being unreachable, are returned as is and are not cached.
+func (c *Cache) Validate(ctx context.Context, token string) error {
+	c.mu.Lock()
+	e := c.entry(token)
+	interval := c.ValidTokenRecheckInterval
+	if e.validationErr != nil {
+		interval = c.InvalidTokenRetryInterval
+	}
+	if e.validated && c.now().Sub(e.validatedAt) < interval {
+		c.mu.Unlock()
+		return e.validationErr
+	}
+	cl := e.client
+	c.mu.Unlock()
+
+	err := checkToken(ctx, cl)
+	if err != nil && !errors.Is(err, ErrInvalidToken) {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The token might have been evicted in the meantime. Don't resurrect the entry.
+	if e, ok := c.entries[token]; ok && e.client == cl {
+		e.validated = true
+		e.validatedAt = c.now()
+		e.validationErr = err
+	}
+	return err
+}
+
+// SecretEventHandler returns an informer event handler evicting the clients of tokens that were rotated or deleted.
+// The token is read from the given key of the secret.
+func (c *Cache) SecretEventHandler(tokenKey string) toolscache.ResourceEventHandler {
+	tokenFromObj := func(obj any) (string, bool) {
+		if d, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+			obj = d.Obj
+		}
+		s, ok := obj.(*corev1.Secret)
+		if !ok {
+			return "", false
+		}
+		t, ok := s.Data[tokenKey]
+		return string(t), ok
+	}
+
+	return toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj any) {
+			oldToken, ok := tokenFromObj(oldObj)
+			if !ok {
+				return
+			}
+			if newToken, _ := tokenFromObj(newObj); newToken != oldToken {
+				c.Evict(oldToken)
+			}
+		},
+		DeleteFunc: func(obj any) {
+			if token, ok := tokenFromObj(obj); ok {
+				c.Evict(token)
+			}
+		},
+	}
+}
+
+// checkToken does a cheap authenticated call to the cloudscale API by listing the regions.
+// Returns an error wrapping ErrInvalidToken if the API rejected the token.
+func checkToken(ctx context.Context, cl *cloudscale.Client) error {
+	_, err := cl.Regions.List(ctx)
+	if err == nil {
+		return nil
+	}
+	var errResp *cloudscale.ErrorResponse
+	if errors.As(err, &errResp) && (errResp.StatusCode == http.StatusUnauthorized || errResp.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return fmt.Errorf("failed to validate cloudscale API token: %w", err)
+}
+
+// entry returns the cache entry for the given token, creating it if necessary.
+// The caller must hold the lock.
+func (c *Cache) entry(token string) *cacheEntry {
+	e, ok := c.entries[token]
+	if !ok {
+		e = &cacheEntry{client: c.newClient(token)}
+		c.entries[token] = e
+	}
+	return e
+}
@@ -0,0 +1,122 @@
+package csclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_Cache_Client(t *testing.T) {
+	t.Parallel()
+
+	var created atomic.Int32
+	c := NewCache(func(token string) *cloudscale.Client {
+		created.Add(1)
+		cs := cloudscale.NewClient(nil)
+		cs.AuthToken = token
+		return cs
+	})
+
+	a := c.Client("token-a")
+	assert.Same(t, a, c.Client("token-a"), "client should be reused for the same token")
+	b := c.Client("token-b")
+	assert.NotSame(t, a, b)
+	assert.Equal(t, "token-b", b.AuthToken)
+	assert.EqualValues(t, 2, created.Load())
+
+	c.Evict("token-a")
+	assert.NotSame(t, a, c.Client("token-a"), "evicted client should be recreated")
+	assert.EqualValues(t, 3, created.Load())
+}
+
+func Test_Cache_Validate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[{"slug":"rma","zones":[{"slug":"rma1"}]}]`))
+		case "Bearer broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"detail":"Invalid token."}`))
+		}
+	}))
+	defer srv.Close()
+	baseURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	c := NewCache(func(token string) *cloudscale.Client {
+		cs := cloudscale.NewClient(srv.Client())
+		cs.BaseURL = baseURL
+		cs.AuthToken = token
+		return cs
+	})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	require.NoError(t, c.Validate(ctx, "valid"))
+	require.NoError(t, c.Validate(ctx, "valid"))
+	assert.EqualValues(t, 1, requests.Load(), "valid tokens should not be validated again before the recheck interval")
+	now = now.Add(c.ValidTokenRecheckInterval)
+	require.NoError(t, c.Validate(ctx, "valid"))
+	assert.EqualValues(t, 2, requests.Load(), "valid tokens should be validated again after the recheck interval")
+
+	require.ErrorIs(t, c.Validate(ctx, "invalid"), ErrInvalidToken)
+	require.ErrorIs(t, c.Validate(ctx, "invalid"), ErrInvalidToken)
+	assert.EqualValues(t, 3, requests.Load(), "invalid tokens should not be validated again before the retry interval")
+	now = now.Add(c.InvalidTokenRetryInterval)
+	require.ErrorIs(t, c.Validate(ctx, "invalid"), ErrInvalidToken)
+	assert.EqualValues(t, 4, requests.Load(), "invalid tokens should be validated again after the retry interval")
+
+	err = c.Validate(ctx, "broken")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidToken)
+	require.Error(t, c.Validate(ctx, "broken"))
+	assert.EqualValues(t, 6, requests.Load(), "unexpected errors should not be cached")
+}
+
+func Test_Cache_SecretEventHandler(t *testing.T) {
+	t.Parallel()
+
+	c := NewCache(func(token string) *cloudscale.Client {
+		cs := cloudscale.NewClient(nil)
+		cs.AuthToken = token
+		return cs
+	})
+	h := c.SecretEventHandler("token")
+
+	secret := func(token string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cloudscale-token"},
+			Data:       map[string][]byte{"token": []byte(token)},
+		}
+	}
+
+	old := c.Client("old")
+	h.OnUpdate(secret("old"), secret("old"))
+	assert.Same(t, old, c.Client("old"), "unchanged token should not be evicted")
+
+	h.OnUpdate(secret("old"), secret("new"))
+	assert.NotSame(t, old, c.Client("old"), "rotated token should be evicted")
+
+	old = c.Client("old")
+	h.OnDelete(secret("old"))
+	assert.NotSame(t, old, c.Client("old"), "deleted token should be evicted")
+}
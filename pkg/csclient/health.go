@@ -0,0 +1,81 @@
+package csclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAPICheckTimeout is the default timeout for the cloudscale API call of the readiness check.
+	DefaultAPICheckTimeout = 5 * time.Second
+	// DefaultAPICheckCacheTTL is the default duration the result of the readiness check is cached.
+	DefaultAPICheckCacheTTL = 30 * time.Second
+)
+
+// ErrNoToken is returned by APICheck.Check if a token is required but none is configured.
+var ErrNoToken = errors.New("no default cloudscale API token configured")
+
+// APICheck is a readiness check verifying that the cloudscale API is reachable and accepts the default token.
+// The result is cached to avoid calling the API on every probe.
+type APICheck struct {
+	cache *Cache
+	token func() string
+
+	// Timeout is the timeout for the cloudscale API call.
+	Timeout time.Duration
+	// CacheTTL is the duration the result of a check is cached.
+	CacheTTL time.Duration
+	// RequireToken fails the check with ErrNoToken if the token is empty.
+	// Otherwise the check passes without calling the API, machines with a TokenSecret work without a default token.
+	RequireToken bool
+
+	now func() time.Time
+
+	mu           sync.Mutex
+	checkedAt    time.Time
+	checkedToken string
+	err          error
+}
+
+// NewAPICheck returns a readiness check using the client for the token returned by the given function.
+func NewAPICheck(c *Cache, token func() string) *APICheck {
+	return &APICheck{
+		cache: c,
+		token: token,
+
+		Timeout:  DefaultAPICheckTimeout,
+		CacheTTL: DefaultAPICheckCacheTTL,
+
+		now: time.Now,
+	}
+}
+
+// Check implements healthz.Checker.
+// It fails if the cloudscale API is unreachable or rejects the token.
+// Concurrent probes wait for a running check instead of calling the API again.
+func (ch *APICheck) Check(req *http.Request) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	token := ch.token()
+	if token == "" {
+		if ch.RequireToken {
+			return ErrNoToken
+		}
+		return nil
+	}
+	if token == ch.checkedToken && !ch.checkedAt.IsZero() && ch.now().Sub(ch.checkedAt) < ch.CacheTTL {
+		return ch.err
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), ch.Timeout)
+	defer cancel()
+
+	ch.err = checkToken(ctx, ch.cache.Client(token))
+	ch.checkedToken = token
+	ch.checkedAt = ch.now()
+	return ch.err
+}
@@ -0,0 +1,72 @@
+package csclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_APICheck(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	var unavailable atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if unavailable.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"detail":"Invalid token."}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	baseURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	c := NewCache(func(token string) *cloudscale.Client {
+		cs := cloudscale.NewClient(srv.Client())
+		cs.BaseURL = baseURL
+		cs.AuthToken = token
+		return cs
+	})
+
+	token := "valid"
+	check := NewAPICheck(c, func() string { return token })
+	now := time.Now()
+	check.now = func() time.Time { return now }
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+
+	require.NoError(t, check.Check(req))
+	require.NoError(t, check.Check(req))
+	assert.EqualValues(t, 1, requests.Load(), "result should be cached")
+
+	unavailable.Store(true)
+	require.NoError(t, check.Check(req), "cached result should be returned until the TTL expires")
+	now = now.Add(check.CacheTTL)
+	require.ErrorContains(t, check.Check(req), "failed to validate cloudscale API token")
+	assert.EqualValues(t, 2, requests.Load())
+
+	unavailable.Store(false)
+	token = "rotated-invalid"
+	require.ErrorIs(t, check.Check(req), ErrInvalidToken, "a changed token should be checked immediately")
+	assert.EqualValues(t, 3, requests.Load())
+
+	token = ""
+	require.NoError(t, check.Check(req), "no token is fine if the token is not required")
+	check.RequireToken = true
+	require.ErrorIs(t, check.Check(req), ErrNoToken)
+	assert.EqualValues(t, 3, requests.Load(), "the API should not be called without a token")
+}
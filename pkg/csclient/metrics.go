@@ -0,0 +1,48 @@
+package csclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// validationTimeout is the timeout for validating the default token when collecting metrics.
+const validationTimeout = 10 * time.Second
+
+// RegisterDefaultTokenMetrics registers metrics reporting the age and validity of the default token with the given registry.
+// The validity is 1 if the token is valid, 0 if it was rejected by the API, and NaN if the validity could not be determined.
+func RegisterDefaultTokenMetrics(reg prometheus.Registerer, c *Cache, tf *TokenFile) error {
+	age := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "machine_api_provider_cloudscale_default_token_age_seconds",
+		Help: "Seconds since the default cloudscale API token was last changed.",
+	}, func() float64 {
+		return tf.Age().Seconds()
+	})
+	valid := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "machine_api_provider_cloudscale_default_token_valid",
+		Help: "Whether the default cloudscale API token is accepted by the cloudscale API. 1 if valid, 0 if invalid, NaN if unknown.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+		defer cancel()
+		err := c.Validate(ctx, tf.Token())
+		switch {
+		case err == nil:
+			return 1
+		case errors.Is(err, ErrInvalidToken):
+			return 0
+		default:
+			return math.NaN()
+		}
+	})
+
+	for _, col := range []prometheus.Collector{age, valid} {
+		if err := reg.Register(col); err != nil {
+			return fmt.Errorf("failed to register default token metrics: %w", err)
+		}
+	}
+	return nil
+}
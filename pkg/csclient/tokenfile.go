@@ -0,0 +1,151 @@
+package csclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DefaultTokenFileResyncInterval is the default interval in which the token file is re-read, independent of file system events.
+const DefaultTokenFileResyncInterval = time.Minute
+
+// TokenFile loads a cloudscale API token from a file and reloads it if the file changes.
+// It supports files mounted from projected volumes or secrets, which are updated by atomically swapping a symlink.
+// TokenFile implements manager.Runnable and runs on all replicas, independent of leader election.
+type TokenFile struct {
+	path string
+
+	// ResyncInterval is the interval in which the file is re-read, independent of file system events.
+	ResyncInterval time.Duration
+
+	mu        sync.RWMutex
+	token     string
+	changedAt time.Time
+	onChange  []func(token string)
+}
+
+// NewTokenFile reads the token from the given file.
+// Leading and trailing whitespace is removed from the token.
+func NewTokenFile(path string) (*TokenFile, error) {
+	f := &TokenFile{
+		path:           path,
+		ResyncInterval: DefaultTokenFileResyncInterval,
+	}
+
+	token, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	f.token = token
+	f.changedAt = time.Now()
+	if fi, err := os.Stat(path); err == nil {
+		f.changedAt = fi.ModTime()
+	}
+
+	return f, nil
+}
+
+// Token returns the current token.
+func (f *TokenFile) Token() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.token
+}
+
+// Age returns the time since the token last changed.
+// For the initially loaded token, the modification time of the file is used.
+func (f *TokenFile) Age() time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return time.Since(f.changedAt)
+}
+
+// OnChange registers a function that is called with the new token every time the token changes.
+func (f *TokenFile) OnChange(fn func(token string)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onChange = append(f.onChange, fn)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+// The token must be reloaded on all replicas.
+func (f *TokenFile) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the token file for changes until the context is canceled.
+func (f *TokenFile) Start(ctx context.Context) error {
+	l := log.FromContext(ctx).WithName("TokenFile").WithValues("path", f.path)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer w.Close()
+	// Watch the directory, files in projected volumes are replaced by swapping a symlink.
+	if err := w.Add(filepath.Dir(f.path)); err != nil {
+		return fmt.Errorf("failed to watch directory of token file %q: %w", f.path, err)
+	}
+
+	resync := time.NewTicker(f.ResyncInterval)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.Events:
+		case err := <-w.Errors:
+			l.Error(err, "Error watching token file")
+			continue
+		case <-resync.C:
+		}
+
+		if err := f.reload(ctx); err != nil {
+			l.Error(err, "Failed to reload token file")
+		}
+	}
+}
+
+// reload reads the token file and notifies the registered functions if the token changed.
+func (f *TokenFile) reload(ctx context.Context) error {
+	token, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if token == f.token {
+		f.mu.Unlock()
+		return nil
+	}
+	f.token = token
+	f.changedAt = time.Now()
+	onChange := append([]func(string){}, f.onChange...)
+	f.mu.Unlock()
+
+	log.FromContext(ctx).WithName("TokenFile").Info("Token file changed, reloaded token", "path", f.path)
+	for _, fn := range onChange {
+		fn(token)
+	}
+	return nil
+}
+
+func (f *TokenFile) read() (string, error) {
+	raw, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file %q: %w", f.path, err)
+	}
+	token := string(bytes.TrimSpace(raw))
+	if token == "" {
+		return "", fmt.Errorf("token file %q is empty", f.path)
+	}
+	return token, nil
+}
@@ -0,0 +1,76 @@
+package csclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TokenFile(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(path, []byte("initial-token\n"), 0o600))
+
+	tf, err := NewTokenFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "initial-token", tf.Token(), "whitespace should be trimmed")
+
+	changed := make(chan string, 1)
+	tf.OnChange(func(token string) {
+		changed <- token
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- tf.Start(ctx)
+	}()
+
+	// Give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	// Replace the file atomically, as done by the kubelet for projected volumes
+	tmp := filepath.Join(dir, "token.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("rotated-token"), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+
+	select {
+	case token := <-changed:
+		assert.Equal(t, "rotated-token", token)
+	case <-time.After(5 * time.Second):
+		t.Fatal("token change was not picked up")
+	}
+	assert.Equal(t, "rotated-token", tf.Token())
+	assert.Less(t, tf.Age(), 5*time.Second)
+
+	// An empty file should not replace a valid token
+	require.NoError(t, os.WriteFile(path, []byte(""), 0o600))
+	require.Error(t, tf.reload(ctx))
+	assert.Equal(t, "rotated-token", tf.Token())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func Test_NewTokenFile_Errors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	_, err := NewTokenFile(filepath.Join(dir, "missing"))
+	require.ErrorContains(t, err, "failed to read token file")
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(empty, []byte(" \n"), 0o600))
+	_, err = NewTokenFile(empty)
+	require.ErrorContains(t, err, "is empty")
+}
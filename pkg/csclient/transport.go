@@ -0,0 +1,103 @@
+package csclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+)
+
+// DefaultRequestTimeout is the default timeout for requests to the cloudscale API.
+const DefaultRequestTimeout = time.Minute
+
+// HTTPConfig configures how the clients communicate with the cloudscale API.
+type HTTPConfig struct {
+	// BaseURL overrides the base URL of the cloudscale API.
+	// If empty, the SDK default or the CLOUDSCALE_API_URL environment variable is used.
+	BaseURL string
+	// ProxyURL is the URL of the HTTP(S) proxy to use.
+	// If empty, the proxy is read from the HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables.
+	ProxyURL string
+	// CABundleFile is a file with PEM encoded CA certificates to trust in addition to the system CAs.
+	CABundleFile string
+	// Timeout is the timeout for a single request to the cloudscale API.
+	// Zero means no timeout.
+	Timeout time.Duration
+	// UserAgent is the user agent sent with every request.
+	UserAgent string
+}
+
+// NewClientFunc returns a function creating cloudscale API clients for a token.
+// All clients created by the returned function share a single HTTP client.
+func (c HTTPConfig) NewClientFunc() (func(token string) *cloudscale.Client, error) {
+	var baseURL *url.URL
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cloudscale API base URL %q: %w", c.BaseURL, err)
+		}
+		// API paths are resolved relative to the base URL
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		baseURL = u
+	}
+
+	hc, err := c.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(token string) *cloudscale.Client {
+		cs := cloudscale.NewClient(hc)
+		if baseURL != nil {
+			cs.BaseURL = baseURL
+		}
+		if c.UserAgent != "" {
+			cs.UserAgent = c.UserAgent
+		}
+		cs.AuthToken = token
+		return cs
+	}, nil
+}
+
+func (c HTTPConfig) httpClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", c.ProxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	if c.CABundleFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(c.CABundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %q: %w", c.CABundleFile, err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in CA bundle %q", c.CABundleFile)
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   c.Timeout,
+	}, nil
+}
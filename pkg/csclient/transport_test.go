@@ -0,0 +1,90 @@
+package csclient
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HTTPConfig_BaseURLAndCABundle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var gotPath, gotUserAgent string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUserAgent = r.UserAgent()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o600))
+
+	untrusted, err := HTTPConfig{BaseURL: srv.URL + "/api"}.NewClientFunc()
+	require.NoError(t, err)
+	_, err = untrusted("token").Regions.List(ctx)
+	require.ErrorContains(t, err, "certificate", "server certificate should not be trusted without CA bundle")
+
+	newClient, err := HTTPConfig{
+		BaseURL:      srv.URL + "/api",
+		CABundleFile: caFile,
+		UserAgent:    "test-agent",
+	}.NewClientFunc()
+	require.NoError(t, err)
+	_, err = newClient("token").Regions.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v1/regions", gotPath)
+	assert.Equal(t, "test-agent", gotUserAgent)
+}
+
+func Test_HTTPConfig_Proxy(t *testing.T) {
+	t.Parallel()
+
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		assert.Equal(t, "http://cloudscale.invalid/v1/regions", r.URL.String(), "proxy should receive the absolute request URL")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer proxy.Close()
+
+	newClient, err := HTTPConfig{
+		BaseURL:  "http://cloudscale.invalid",
+		ProxyURL: proxy.URL,
+	}.NewClientFunc()
+	require.NoError(t, err)
+	_, err = newClient("token").Regions.List(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, proxied.Load())
+}
+
+func Test_HTTPConfig_Errors(t *testing.T) {
+	t.Parallel()
+
+	invalidCA := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(invalidCA, []byte("not a certificate"), 0o600))
+
+	_, err := HTTPConfig{CABundleFile: invalidCA}.NewClientFunc()
+	require.ErrorContains(t, err, "no valid certificates")
+
+	_, err = HTTPConfig{CABundleFile: filepath.Join(t.TempDir(), "missing")}.NewClientFunc()
+	require.ErrorContains(t, err, "failed to read CA bundle")
+
+	_, err = HTTPConfig{ProxyURL: "://invalid"}.NewClientFunc()
+	require.ErrorContains(t, err, "invalid proxy URL")
+}
@@ -0,0 +1,145 @@
+// Package jsonnetlimits limits the resources used to evaluate Jsonnet templates inside the controllers.
+package jsonnetlimits
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-jsonnet"
+)
+
+const (
+	// DefaultMaxStack is the default maximum number of Jsonnet stack frames.
+	DefaultMaxStack = 500
+	// DefaultTimeout is the default timeout for evaluating a Jsonnet template.
+	DefaultTimeout = 10 * time.Second
+	// DefaultMaxOutputSize is the default maximum size in bytes of the output of a Jsonnet template.
+	DefaultMaxOutputSize = 1024 * 1024
+)
+
+// ErrOutputTooLarge is returned if the output of a Jsonnet template exceeds the maximum size.
+var ErrOutputTooLarge = errors.New("jsonnet output exceeds the maximum size")
+
+// ErrStillRunning is returned if a previous evaluation of a Jsonnet template did not complete within the timeout.
+var ErrStillRunning = errors.New("a previous evaluation of the jsonnet template is still running")
+
+// timedOut holds the evaluations that did not complete within the timeout by the hash of their snippet and inputs,
+// the channels are closed when the evaluation completes.
+// An evaluation timing out is likely to time out again. Evaluations can't be interrupted,
+// so the same snippet with the same inputs is not evaluated again until its previous evaluation completed to keep aborted evaluations from piling up.
+var timedOut = struct {
+	sync.Mutex
+	evaluations map[[sha256.Size]byte]chan struct{}
+}{evaluations: map[[sha256.Size]byte]chan struct{}{}}
+
+// Limits are the limits applied when evaluating a Jsonnet template.
+// Zero values are replaced by the defaults.
+type Limits struct {
+	// MaxStack is the maximum number of Jsonnet stack frames.
+	// Note that go-jsonnet does not count calls marked as tailstrict.
+	MaxStack int
+	// Timeout is the maximum duration of the evaluation.
+	Timeout time.Duration
+	// MaxOutputSize is the maximum size in bytes of the output.
+	MaxOutputSize int
+}
+
+// EvaluateAnonymousSnippet evaluates the snippet with the given VM within the limits.
+// The inputs identify the values the VM passes to the snippet, such as external variables.
+// The evaluation is aborted when the timeout expires or the context is canceled.
+// go-jsonnet can't interrupt a running evaluation; it continues in the background until it completes and its result is discarded.
+// Evaluations of the same snippet with the same inputs as an evaluation that was aborted wait for it to complete
+// and fail with ErrStillRunning if it doesn't complete within the timeout.
+// The output size is checked after the evaluation, the memory used to build the output is only bounded by the timeout.
+func (l Limits) EvaluateAnonymousSnippet(ctx context.Context, vm *jsonnet.VM, filename, snippet, inputs string) (string, error) {
+	vm.MaxStack = withDefault(l.MaxStack, DefaultMaxStack)
+	timeout := withDefault(l.Timeout, DefaultTimeout)
+	maxOutputSize := withDefault(l.MaxOutputSize, DefaultMaxOutputSize)
+
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("jsonnet evaluation aborted: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	key := evaluationKey(filename, snippet, inputs)
+	timedOut.Lock()
+	prev, ok := timedOut.evaluations[key]
+	timedOut.Unlock()
+	if ok {
+		select {
+		case <-prev:
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %w", ErrStillRunning, ctx.Err())
+		}
+	}
+
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	completed := make(chan struct{})
+	go func() {
+		defer func() {
+			timedOut.Lock()
+			close(completed)
+			if timedOut.evaluations[key] == completed {
+				delete(timedOut.evaluations, key)
+			}
+			timedOut.Unlock()
+		}()
+		output, err := vm.EvaluateAnonymousSnippet(filename, snippet)
+		done <- result{output, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		timedOut.Lock()
+		select {
+		case <-completed:
+		default:
+			if _, ok := timedOut.evaluations[key]; !ok {
+				timedOut.evaluations[key] = completed
+			}
+		}
+		timedOut.Unlock()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("jsonnet evaluation did not complete within %s: %w", timeout, ctx.Err())
+		}
+		return "", fmt.Errorf("jsonnet evaluation aborted: %w", ctx.Err())
+	case r := <-done:
+		if r.err != nil {
+			return "", r.err
+		}
+		if len(r.output) > maxOutputSize {
+			return "", fmt.Errorf("%w: output is %d bytes, maximum is %d bytes", ErrOutputTooLarge, len(r.output), maxOutputSize)
+		}
+		return r.output, nil
+	}
+}
+
+// evaluationKey returns the hash of the filename, snippet and inputs of an evaluation.
+func evaluationKey(filename, snippet, inputs string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, s := range []string{filename, snippet, inputs} {
+		// Prefix each value with its length so different values can't produce the same hash input.
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+func withDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,152 @@
+package jsonnetlimits
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Limits_EvaluateAnonymousSnippet(t *testing.T) {
+	t.Parallel()
+
+	// slow takes a few hundred milliseconds to evaluate
+	const slow = `std.foldl(function(a, b) a + b, std.range(0, 300000), 0)`
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tcs := []struct {
+		name     string
+		ctx      context.Context
+		limits   Limits
+		snippet  string
+		expected string
+		errorMsg string
+		errorIs  error
+	}{
+		{
+			name:     "within limits",
+			snippet:  `{ a: std.repeat('a', 10) }`,
+			expected: "{\n   \"a\": \"aaaaaaaaaa\"\n}\n",
+		},
+		{
+			name:     "max stack",
+			limits:   Limits{MaxStack: 50},
+			snippet:  `local f(n) = if n == 0 then 0 else f(n - 1) + 1; f(100)`,
+			errorMsg: "max stack frames exceeded",
+		},
+		{
+			name:     "default max stack",
+			snippet:  `local f(n) = if n == 0 then 0 else f(n - 1) + 1; f(1000)`,
+			errorMsg: "max stack frames exceeded",
+		},
+		{
+			name:     "timeout",
+			limits:   Limits{Timeout: 10 * time.Millisecond},
+			snippet:  slow,
+			errorMsg: "jsonnet evaluation did not complete within 10ms",
+			errorIs:  context.DeadlineExceeded,
+		},
+		{
+			name:     "canceled context",
+			ctx:      canceled,
+			snippet:  slow,
+			errorMsg: "jsonnet evaluation aborted",
+			errorIs:  context.Canceled,
+		},
+		{
+			name:     "output too large",
+			limits:   Limits{MaxOutputSize: 100},
+			snippet:  `std.repeat('a', 100)`,
+			errorMsg: "output is 103 bytes, maximum is 100 bytes",
+			errorIs:  ErrOutputTooLarge,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			out, err := tc.limits.EvaluateAnonymousSnippet(ctx, jsonnet.MakeVM(), "test", tc.snippet, "")
+			if tc.errorMsg != "" {
+				require.ErrorContains(t, err, tc.errorMsg)
+				if tc.errorIs != nil {
+					require.ErrorIs(t, err, tc.errorIs)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, out)
+		})
+	}
+}
+
+// Test_Limits_EvaluateAnonymousSnippet_RepeatedTimeouts is not parallel, other tests would change the number of goroutines.
+func Test_Limits_EvaluateAnonymousSnippet_RepeatedTimeouts(t *testing.T) {
+	const slow = `std.foldl(function(a, b) a + b, std.range(0, 1000000), 0)`
+	limits := Limits{Timeout: 10 * time.Millisecond}
+
+	before := runtime.NumGoroutine()
+	for i := range 20 {
+		_, err := limits.EvaluateAnonymousSnippet(context.Background(), jsonnet.MakeVM(), "test", slow, "")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		if i > 0 {
+			require.ErrorIs(t, err, ErrStillRunning, "the template should not be evaluated again while the previous evaluation is running")
+		}
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before+1, "at most one evaluation of the template should run in the background")
+
+	// The template is evaluated again once the previous evaluation completed.
+	limits.Timeout = time.Minute
+	out, err := limits.EvaluateAnonymousSnippet(context.Background(), jsonnet.MakeVM(), "test", slow, "")
+	require.NoError(t, err)
+	assert.Equal(t, "500000500000\n", out)
+}
+
+func Test_Limits_EvaluateAnonymousSnippet_TimeoutOtherInputs(t *testing.T) {
+	t.Parallel()
+
+	const snippet = `if std.extVar('slow') then std.foldl(function(a, b) a + b, std.range(0, 1000000), 0) else 0`
+	limits := Limits{Timeout: 10 * time.Millisecond}
+
+	vm := jsonnet.MakeVM()
+	vm.ExtCode("slow", "true")
+	_, err := limits.EvaluateAnonymousSnippet(context.Background(), vm, "test", snippet, "slow")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	vm = jsonnet.MakeVM()
+	vm.ExtCode("slow", "false")
+	out, err := limits.EvaluateAnonymousSnippet(context.Background(), vm, "test", snippet, "fast")
+	require.NoError(t, err, "evaluations with other inputs should not wait for the timed out evaluation")
+	assert.Equal(t, "0\n", out)
+}
+
+func Test_Limits_EvaluateAnonymousSnippet_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	const snippet = `std.foldl(function(a, b) a + b, std.range(0, 1000), 0)`
+
+	errs := make(chan error, 10)
+	for range cap(errs) {
+		go func() {
+			_, err := Limits{}.EvaluateAnonymousSnippet(context.Background(), jsonnet.MakeVM(), "test", snippet, "")
+			errs <- err
+		}()
+	}
+	for range cap(errs) {
+		err := <-errs
+		require.False(t, errors.Is(err, ErrStillRunning), "concurrent evaluations of the same template should not wait for each other")
+		require.NoError(t, err)
+	}
+}
@@ -1,27 +1,33 @@
 package machine
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"maps"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
-	"github.com/google/go-jsonnet"
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	cloudscalev1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/csclient"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/jsonnetlimits"
 )
 
 const (
@@ -30,6 +36,41 @@ const (
 	machineClusterIDTag = "machine-api-provider-cloudscale_appuio_io_cluster_id"
 
 	machineClusterIDLabelName = "machine.openshift.io/cluster-api-cluster"
+
+	// serverNotReadyRequeueAfter is the delay after which a machine is reconciled again while its server is not running or has no addresses yet.
+	serverNotReadyRequeueAfter = 10 * time.Second
+	// serverGroupNotReadyRequeueAfter is the delay before retrying to create a machine whose CloudscaleServerGroup has no server group yet.
+	serverGroupNotReadyRequeueAfter = 10 * time.Second
+
+	// TokenSecretKey is the key of the cloudscale API token in the secret referenced by the TokenSecret of the provider spec.
+	TokenSecretKey = "token"
+	// ManagedServerGroupTag marks server groups created by the provider for ServerGroupNames or a CloudscaleServerGroup.
+	ManagedServerGroupTag = "machine-api-provider-cloudscale_appuio_io_managed"
+)
+
+// Event reasons emitted on the Machine object.
+const (
+	eventReasonCreated               = "Created"
+	eventReasonServerGroupSelected   = "ServerGroupSelected"
+	eventReasonServerGroupCreated    = "ServerGroupCreated"
+	eventReasonRootVolumeTagged      = "RootVolumeTagged"
+	eventReasonServerTagsUpdated     = "ServerTagsUpdated"
+	eventReasonRootVolumeTagsUpdated = "RootVolumeTagsUpdated"
+	eventReasonInterfacesUpdated     = "InterfacesUpdated"
+	eventReasonDeleted               = "Deleted"
+
+	eventReasonCapacityFallback = "CapacityFallback"
+
+	eventReasonFailedCreate = "FailedCreate"
+	eventReasonFailedUpdate = "FailedUpdate"
+	eventReasonFailedDelete = "FailedDelete"
+	eventReasonInvalidToken = "InvalidToken"
+)
+
+// Condition reasons set in the provider status.
+const (
+	conditionReasonTokenValid   = "TokenValid"
+	conditionReasonTokenInvalid = "TokenInvalid"
 )
 
 // Actuator is responsible for performing machine reconciliation.
@@ -37,106 +78,188 @@ const (
 // Currently changing machine spec is not supported.
 // The user data is rendered using Jsonnet with the machine and secret data as context.
 // Machines are automatically spread across server groups on create based on the AntiAffinityKey.
+// Lifecycle actions and failures are recorded as Kubernetes Events on the Machine.
 type Actuator struct {
-	k8sClient client.Client
+	k8sClient     client.Client
+	eventRecorder record.EventRecorder
+
+	defaultCloudscaleAPIToken atomic.Pointer[string]
+	tokenValidator            func(ctx context.Context, token string) error
+
+	maxUserDataSize int
+	providerVersion string
+	jsonnetLimits   jsonnetlimits.Limits
 
-	defaultCloudscaleAPIToken string
+	userDataSecretPolicy UserDataSecretPolicy
+	reconcileInterfaces  bool
 
 	serverClientFactory      func(token string) cloudscale.ServerService
 	serverGroupClientFactory func(token string) cloudscale.ServerGroupService
 	volumeClientFactory      func(token string) cloudscale.VolumeService
+	networkClientFactory     func(token string) cloudscale.NetworkService
+	subnetClientFactory      func(token string) cloudscale.SubnetService
+
+	addressRangeAllocator addressRangeAllocator
+
+	quota      Quota
+	quotaUsage quotaUsageCache
 }
 
 // ActuatorParams holds parameter information for Actuator.
 type ActuatorParams struct {
 	K8sClient client.Client
+	// EventRecorder records the events of the actuator on machines.
+	// Events are discarded if nil.
+	EventRecorder record.EventRecorder
 
 	DefaultCloudscaleAPIToken string
+	// TokenValidator is an optional function validating the cloudscale API token of a machine.
+	// It must return an error wrapping csclient.ErrInvalidToken if the token was rejected by the API.
+	// Other errors are ignored, the following API calls will fail if the API is unreachable.
+	TokenValidator func(ctx context.Context, token string) error
+
+	// MaxUserDataSize is the maximum size in bytes of the user data sent to the cloudscale API.
+	// Defaults to DefaultMaxUserDataSize.
+	MaxUserDataSize int
+	// ProviderVersion is the version of the provider exposed to the user data Jsonnet template.
+	ProviderVersion string
+	// JsonnetLimits are the limits for evaluating the user data Jsonnet template.
+	JsonnetLimits jsonnetlimits.Limits
+	// UserDataSecretPolicy restricts the secrets UserDataSecretSelector can pass to the user data Jsonnet template.
+	UserDataSecretPolicy UserDataSecretPolicy
+	// ReconcileInterfaces enables attaching and detaching networks in Update if the interfaces of the provider spec change.
+	ReconcileInterfaces bool
+	// Quota is the quota checked before creating a server.
+	// The quota check is disabled if all limits are zero.
+	Quota Quota
 
 	ServerClientFactory      func(token string) cloudscale.ServerService
 	ServerGroupClientFactory func(token string) cloudscale.ServerGroupService
 	VolumeClientFactory      func(token string) cloudscale.VolumeService
+	NetworkClientFactory     func(token string) cloudscale.NetworkService
+	SubnetClientFactory      func(token string) cloudscale.SubnetService
 }
 
 // NewActuator returns an actuator.
 func NewActuator(params ActuatorParams) *Actuator {
-	return &Actuator{
-		k8sClient: params.K8sClient,
+	a := &Actuator{
+		k8sClient:     params.K8sClient,
+		eventRecorder: params.EventRecorder,
+
+		tokenValidator: params.TokenValidator,
+
+		maxUserDataSize: params.MaxUserDataSize,
+		providerVersion: params.ProviderVersion,
+		jsonnetLimits:   params.JsonnetLimits,
+
+		userDataSecretPolicy: params.UserDataSecretPolicy,
+		reconcileInterfaces:  params.ReconcileInterfaces,
 
-		defaultCloudscaleAPIToken: params.DefaultCloudscaleAPIToken,
+		quota: params.Quota,
 
 		serverClientFactory:      params.ServerClientFactory,
 		serverGroupClientFactory: params.ServerGroupClientFactory,
 		volumeClientFactory:      params.VolumeClientFactory,
+		networkClientFactory:     params.NetworkClientFactory,
+		subnetClientFactory:      params.SubnetClientFactory,
 	}
+	if a.eventRecorder == nil {
+		a.eventRecorder = discardEventRecorder{}
+	}
+	if a.maxUserDataSize <= 0 {
+		a.maxUserDataSize = DefaultMaxUserDataSize
+	}
+	if a.quota.CacheDuration <= 0 {
+		a.quota.CacheDuration = DefaultQuotaCacheDuration
+	}
+	a.SetDefaultCloudscaleAPIToken(params.DefaultCloudscaleAPIToken)
+	return a
+}
+
+// discardEventRecorder is a record.EventRecorder discarding all events.
+type discardEventRecorder struct{}
+
+func (discardEventRecorder) Event(runtime.Object, string, string, string) {}
+
+func (discardEventRecorder) Eventf(runtime.Object, string, string, string, ...any) {}
+
+func (discardEventRecorder) AnnotatedEventf(runtime.Object, map[string]string, string, string, string, ...any) {
+}
+
+// SetDefaultCloudscaleAPIToken atomically replaces the token used for machines without a TokenSecret.
+func (a *Actuator) SetDefaultCloudscaleAPIToken(token string) {
+	a.defaultCloudscaleAPIToken.Store(&token)
 }
 
 // Create creates a machine and is invoked by the machine controller.
 func (a *Actuator) Create(ctx context.Context, machine *machinev1beta1.Machine) error {
-	l := log.FromContext(ctx).WithName("Actuator.Create")
-
 	mctx, err := a.getMachineContext(ctx, machine)
 	if err != nil {
-		return fmt.Errorf("failed to get machine context: %w", err)
+		return a.handleMachineError(machine, fmt.Errorf("failed to get machine context: %w", err), eventReasonFailedCreate)
 	}
-	spec := mctx.spec
-	sc := a.serverClientFactory(mctx.token)
-
-	userData, err := a.loadAndRenderUserDataSecret(ctx, mctx)
-	if err != nil {
-		return fmt.Errorf("failed to load user data secret: %w", err)
+	if err := a.create(ctx, machine, mctx); err != nil {
+		if isExpectedRequeue(err) {
+			return err
+		}
+		return a.handleMachineError(machine, mctx.redactError(err), eventReasonFailedCreate)
 	}
+	return nil
+}
 
-	// prepare server tags by combining fixed and user-provided tags
-	serverTags := buildServerTags(machine.Name, mctx.clusterId, spec.Tags)
+func (a *Actuator) create(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext) error {
+	l := log.FromContext(ctx).WithName("Actuator.Create")
 
-	// Null is not allowed for SSH keys in the cloudscale API
-	if spec.SSHKeys == nil {
-		spec.SSHKeys = []string{}
-	}
+	sc := a.serverClientFactory(mctx.token)
 
-	serverGroups := spec.ServerGroups
-	if spec.AntiAffinityKey != "" {
-		sgc := a.serverGroupClientFactory(mctx.token)
-		aasg, err := a.ensureAntiAffinityServerGroupForKey(ctx, sgc, spec.Zone, spec.AntiAffinityKey)
-		if err != nil {
-			return fmt.Errorf("failed to ensure anti-affinity server group for machine %q and key %q: %w", machine.Name, spec.AntiAffinityKey, err)
-		}
-		serverGroups = append(serverGroups, aasg)
+	if err := a.validateToken(ctx, machine, mctx); err != nil {
+		return err
 	}
 
-	name := machine.Name
-	if spec.BaseDomain != "" {
-		name = fmt.Sprintf("%s.%s", name, spec.BaseDomain)
+	if err := validateZoneFallbacks(mctx.spec); err != nil {
+		return err
 	}
 
-	req := &cloudscale.ServerRequest{
-		Name: name,
+	// The quota is checked before anything is created for the machine.
+	flavors, err := a.checkQuota(ctx, machine, mctx)
+	if err != nil {
+		return err
+	}
+	mctx.flavors = flavors
 
-		TaggedResourceRequest: cloudscale.TaggedResourceRequest{
-			Tags: ptr.To(cloudscale.TagMap(serverTags)),
-		},
-		Zone: spec.Zone,
-		ZonalResourceRequest: cloudscale.ZonalResourceRequest{
-			Zone: spec.Zone,
-		},
+	if err := a.selectZone(ctx, machine, mctx); err != nil {
+		return fmt.Errorf("failed to select zone of machine %q: %w", machine.Name, err)
+	}
 
-		Flavor:       spec.Flavor,
-		Image:        spec.Image,
-		VolumeSizeGB: spec.RootVolumeSizeGB,
-		Interfaces:   cloudscaleServerInterfacesFromProviderSpecInterfaces(spec.Interfaces),
-		SSHKeys:      spec.SSHKeys,
-		UseIPV6:      spec.UseIPV6,
-		ServerGroups: serverGroups,
-		UserData:     userData,
+	var s *cloudscale.Server
+	var userData string
+	var interfaces []csv1beta1.Interface
+	zones := serverZones(mctx.spec)
+	for i, zone := range zones {
+		mctx.spec.Zone = zone
+		var err error
+		s, userData, interfaces, err = a.createInZone(ctx, sc, machine, mctx)
+		if err == nil {
+			break
+		}
+		if !isCapacityError(err) || i == len(zones)-1 {
+			// The user data is stored for debugging even if the server can't be created.
+			if serr := a.storeRenderedUserDataIfEnabled(ctx, machine, mctx, userData); serr != nil {
+				return errors.Join(err, serr)
+			}
+			return err
+		}
+		l.Info("Zone has no capacity, falling back to next zone", "machine", machine.Name, "zone", zone, "fallback", zones[i+1], "error", mctx.redactError(err))
+		a.eventRecorder.Eventf(machine, corev1.EventTypeWarning, eventReasonCapacityFallback, "Failed to create server in zone %q for capacity reasons, falling back to zone %q", zone, zones[i+1])
+		a.deleteCreatedServerGroups(ctx, mctx)
 	}
-	s, err := sc.Create(ctx, req)
-	if err != nil {
-		reqRaw, _ := json.Marshal(req)
-		return fmt.Errorf("failed to create machine %q: %w, req:%+v", machine.Name, err, string(reqRaw))
+	spec := mctx.spec
+
+	if err := a.storeRenderedUserDataIfEnabled(ctx, machine, mctx, userData); err != nil {
+		return err
 	}
 
 	l.Info("Created machine", "machine", machine.Name, "uuid", s.UUID, "server", s)
+	a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonCreated, "Created server %q with UUID %q", s.Name, s.UUID)
 
 	// Tag the RootVolume if tags are set
 	// It can take some time for CloudScale to populate the root volume UUID
@@ -189,17 +312,157 @@ func (a *Actuator) Create(ctx context.Context, machine *machinev1beta1.Machine)
 		}
 
 		l.Info("Tagged volume", "volume", rootVolumeUUID, "machine", machine.Name, "uuid", s.UUID, "server", s)
+		a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonRootVolumeTagged, "Tagged root volume %q", rootVolumeUUID)
 	}
 
-	if err := updateMachineFromCloudscaleServer(machine, *s); err != nil {
+	if err := updateMachineFromCloudscaleServer(machine, mctx.spec.Interfaces, *s); err != nil {
 		return fmt.Errorf("failed to update machine %q from cloudscale API response: %w", machine.Name, err)
 	}
+	if err := recordUserData(machine, mctx, userData); err != nil {
+		return fmt.Errorf("failed to record user data of machine %q: %w", machine.Name, err)
+	}
+	if err := recordInterfaces(machine, spec.Interfaces, interfaces); err != nil {
+		return fmt.Errorf("failed to record interfaces of machine %q: %w", machine.Name, err)
+	}
 
 	if err := a.patchMachine(ctx, mctx.machine, machine); err != nil {
 		return fmt.Errorf("failed to patch machine %q: %w", machine.Name, err)
 	}
 
-	return nil
+	return serverNotReadyError(machine, mctx.spec.Interfaces, *s)
+}
+
+// createInZone creates the server of the machine in the zone of the spec of the machine context.
+// The server groups, user data and interfaces depend on the zone and are prepared for every zone tried.
+// The server groups created for the zone are recorded in the machine context.
+// The flavors of the machine context are tried in order if creating the server fails for capacity reasons.
+// Returns the created server, the user data and the interfaces it was created with.
+// The user data is also returned if it was rendered before creating the server failed.
+func (a *Actuator) createInZone(ctx context.Context, sc cloudscale.ServerService, machine *machinev1beta1.Machine, mctx *machineContext) (*cloudscale.Server, string, []csv1beta1.Interface, error) {
+	l := log.FromContext(ctx).WithName("Actuator.Create")
+	spec := mctx.spec
+
+	// prepare server tags by combining fixed and user-provided tags
+	serverTags := buildServerTags(machine.Name, mctx.clusterId, spec.Tags)
+
+	// Null is not allowed for SSH keys in the cloudscale API
+	if spec.SSHKeys == nil {
+		spec.SSHKeys = []string{}
+	}
+
+	serverGroups := spec.ServerGroups
+	mctx.createdServerGroups = nil
+	if len(spec.ServerGroupNames) > 0 {
+		sgc := a.serverGroupClientFactory(mctx.token)
+		named, created, err := a.ensureServerGroupsByName(ctx, sgc, machine, spec.Zone, spec.ServerGroupNames)
+		mctx.createdServerGroups = append(mctx.createdServerGroups, created...)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to ensure server groups of machine %q: %w", machine.Name, err)
+		}
+		serverGroups = append(serverGroups, named...)
+	}
+	if spec.AntiAffinityKey != "" {
+		sgc := a.serverGroupClientFactory(mctx.token)
+		aasg, created, err := a.ensureAntiAffinityServerGroupForKey(ctx, sgc, machine, spec.Zone, spec.AntiAffinityKey)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to ensure anti-affinity server group for machine %q and key %q: %w", machine.Name, spec.AntiAffinityKey, err)
+		}
+		if created {
+			mctx.createdServerGroups = append(mctx.createdServerGroups, aasg)
+		}
+		serverGroups = append(serverGroups, aasg)
+	}
+	mctx.serverGroups = serverGroups
+
+	userData, err := a.loadAndRenderUserDataSecret(ctx, mctx)
+	if cerr := a.reportBlockedUserDataSecrets(ctx, machine, mctx, err != nil); cerr != nil {
+		return nil, "", nil, cerr
+	}
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to load user data secret: %w", err)
+	}
+	mctx.sensitiveValues = append(mctx.sensitiveValues, userData)
+
+	interfaces, err := a.resolveInterfaces(ctx, mctx)
+	if err != nil {
+		return nil, userData, nil, fmt.Errorf("failed to resolve interfaces of machine %q: %w", machine.Name, err)
+	}
+	interfaces, err = a.addressesFromPools(ctx, machine, interfaces)
+	if err != nil {
+		return nil, userData, nil, fmt.Errorf("failed to claim addresses from pools for machine %q: %w", machine.Name, err)
+	}
+	interfaces, err = a.addressesFromRanges(ctx, machine, mctx, interfaces)
+	if err != nil {
+		return nil, userData, nil, fmt.Errorf("failed to allocate addresses from ranges for machine %q: %w", machine.Name, err)
+	}
+
+	name := machine.Name
+	if spec.BaseDomain != "" {
+		name = fmt.Sprintf("%s.%s", name, spec.BaseDomain)
+	}
+
+	req := &cloudscale.ServerRequest{
+		Name: name,
+
+		TaggedResourceRequest: cloudscale.TaggedResourceRequest{
+			Tags: ptr.To(cloudscale.TagMap(serverTags)),
+		},
+		Zone: spec.Zone,
+		ZonalResourceRequest: cloudscale.ZonalResourceRequest{
+			Zone: spec.Zone,
+		},
+
+		Image:        spec.Image,
+		VolumeSizeGB: spec.RootVolumeSizeGB,
+		Interfaces:   cloudscaleServerInterfacesFromProviderSpecInterfaces(interfaces),
+		SSHKeys:      spec.SSHKeys,
+		UseIPV6:      spec.UseIPV6,
+		ServerGroups: serverGroups,
+		UserData:     userData,
+	}
+	var createErr error
+	flavors := mctx.flavors
+	for i, flavor := range flavors {
+		if i > 0 {
+			l.Info("Flavor has no capacity, falling back to next flavor", "machine", machine.Name, "zone", spec.Zone, "flavor", flavors[i-1], "fallback", flavor, "error", mctx.redactError(createErr))
+			a.eventRecorder.Eventf(machine, corev1.EventTypeWarning, eventReasonCapacityFallback, "Failed to create server with flavor %q in zone %q for capacity reasons, falling back to flavor %q", flavors[i-1], spec.Zone, flavor)
+		}
+		req.Flavor = flavor
+		s, err := sc.Create(ctx, req)
+		if err == nil {
+			a.quotaUsage.invalidate(mctx.token)
+			return s, userData, interfaces, nil
+		}
+		createErr = fmt.Errorf("failed to create machine %q: %w, req:%s", machine.Name, err, redactedJSON(req))
+		if !isCapacityError(err) {
+			break
+		}
+	}
+	return nil, userData, nil, createErr
+}
+
+// storeRenderedUserDataIfEnabled stores the rendered user data if StoreRenderedUserData is set.
+func (a *Actuator) storeRenderedUserDataIfEnabled(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext, userData string) error {
+	if !mctx.spec.StoreRenderedUserData || userData == "" {
+		return nil
+	}
+	return a.storeRenderedUserData(ctx, machine, userData)
+}
+
+// deleteCreatedServerGroups deletes the server groups created for a zone the server could not be created in.
+// Failing to delete a server group is logged and does not fail the creation of the machine.
+func (a *Actuator) deleteCreatedServerGroups(ctx context.Context, mctx *machineContext) {
+	l := log.FromContext(ctx).WithName("Actuator.deleteCreatedServerGroups")
+
+	sgc := a.serverGroupClientFactory(mctx.token)
+	for _, uuid := range mctx.createdServerGroups {
+		if err := sgc.Delete(ctx, uuid); err != nil {
+			l.Error(mctx.redactError(err), "Failed to delete server group created for zone without capacity", "serverGroup", uuid)
+			continue
+		}
+		l.Info("Deleted server group created for zone without capacity", "serverGroup", uuid)
+	}
+	mctx.createdServerGroups = nil
 }
 
 func tagRootVolume(ctx context.Context, vc cloudscale.VolumeService, uuid string, tags map[string]string) error {
@@ -214,8 +477,7 @@ func tagRootVolume(ctx context.Context, vc cloudscale.VolumeService, uuid string
 		},
 	}
 	if err := vc.Update(ctx, uuid, req); err != nil {
-		reqRaw, _ := json.Marshal(req)
-		return fmt.Errorf("failed to tag root volume %q: %w, req:%s", uuid, err, string(reqRaw))
+		return fmt.Errorf("failed to tag root volume %q: %w, req:%s", uuid, err, redactedJSON(req))
 	}
 	return nil
 }
@@ -239,17 +501,32 @@ func (a *Actuator) Exists(ctx context.Context, machine *machinev1beta1.Machine)
 
 	s, err := a.getServer(ctx, sc, *mctx)
 
-	return s != nil, err
+	return s != nil, mctx.redactError(err)
 }
 
+// Update updates the tags of the server and its root volume and is invoked by the machine controller.
 func (a *Actuator) Update(ctx context.Context, machine *machinev1beta1.Machine) error {
 	mctx, err := a.getMachineContext(ctx, machine)
 	if err != nil {
-		return fmt.Errorf("failed to get machine context: %w", err)
+		return a.handleMachineError(machine, fmt.Errorf("failed to get machine context: %w", err), eventReasonFailedUpdate)
+	}
+	if err := a.update(ctx, machine, mctx); err != nil {
+		if isExpectedRequeue(err) {
+			return err
+		}
+		return a.handleMachineError(machine, mctx.redactError(err), eventReasonFailedUpdate)
 	}
+	return nil
+}
+
+func (a *Actuator) update(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext) error {
 	spec := mctx.spec
 	sc := a.serverClientFactory(mctx.token)
 
+	if err := a.validateToken(ctx, machine, mctx); err != nil {
+		return err
+	}
+
 	s, err := a.getServer(ctx, sc, *mctx)
 	if err != nil {
 		return fmt.Errorf("failed to get server %q: %w", machine.Name, err)
@@ -259,6 +536,13 @@ func (a *Actuator) Update(ctx context.Context, machine *machinev1beta1.Machine)
 		return fmt.Errorf("server not found for machine %q", machine.Name)
 	}
 
+	// Multi-zone machines use the configuration of the zone the server was created in
+	if len(spec.Zones) > 0 {
+		applyZone(&mctx.spec, s.Zone.Slug)
+	}
+	// Servers created in a fallback zone use the zone they were created in
+	applyFallbackZone(&mctx.spec, s.Zone.Slug)
+
 	// 1. Update Server Tags
 	serverTags := buildServerTags(machine.Name, mctx.clusterId, spec.Tags)
 	if !maps.Equal(s.Tags, serverTags) {
@@ -271,6 +555,7 @@ func (a *Actuator) Update(ctx context.Context, machine *machinev1beta1.Machine)
 		if err := sc.Update(ctx, s.UUID, updateReq); err != nil {
 			return fmt.Errorf("failed to update tags for machine %q (server uuid %q): %w", machine.Name, s.UUID, err)
 		}
+		a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonServerTagsUpdated, "Updated tags of server %q", s.UUID)
 	}
 
 	// 2. Update Root Volume Tags
@@ -291,30 +576,50 @@ func (a *Actuator) Update(ctx context.Context, machine *machinev1beta1.Machine)
 			if err := tagRootVolume(ctx, vc, rootVolumeUUID, spec.RootVolumeTags); err != nil {
 				return fmt.Errorf("failed to tag root volume of machine %q: %w", machine.Name, err)
 			}
+			a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonRootVolumeTagsUpdated, "Updated tags of root volume %q", rootVolumeUUID)
 		}
 	} else {
 		// this should not happen for a running server but better to handle it
 		return fmt.Errorf("failed to tag root volume of machine %q: server has no volumes", machine.Name)
 	}
 
-	if err := updateMachineFromCloudscaleServer(machine, *s); err != nil {
+	// 3. Update Interfaces
+	if a.reconcileInterfaces {
+		s, err = a.reconcileServerInterfaces(ctx, sc, machine, mctx, s)
+		if err != nil {
+			return fmt.Errorf("failed to update interfaces of machine %q: %w", machine.Name, err)
+		}
+	}
+
+	if err := updateMachineFromCloudscaleServer(machine, mctx.spec.Interfaces, *s); err != nil {
 		return fmt.Errorf("failed to update machine %q from cloudscale API response: %w", machine.Name, err)
 	}
+	if err := a.checkUserDataDrift(ctx, machine, mctx, *s); err != nil {
+		return fmt.Errorf("failed to check user data of machine %q: %w", machine.Name, err)
+	}
 
 	if err := a.patchMachine(ctx, mctx.machine, machine); err != nil {
 		return fmt.Errorf("failed to patch machine %q: %w", machine.Name, err)
 	}
 
-	return nil
+	return serverNotReadyError(machine, mctx.spec.Interfaces, *s)
 }
 
+// Delete deletes the server of a machine and is invoked by the machine controller.
 func (a *Actuator) Delete(ctx context.Context, machine *machinev1beta1.Machine) error {
-	l := log.FromContext(ctx).WithName("Actuator.Delete")
-
 	mctx, err := a.getMachineContext(ctx, machine)
 	if err != nil {
-		return fmt.Errorf("failed to get machine context: %w", err)
+		return a.handleMachineError(machine, fmt.Errorf("failed to get machine context: %w", err), eventReasonFailedDelete)
+	}
+	if err := a.delete(ctx, machine, mctx); err != nil {
+		return a.handleMachineError(machine, mctx.redactError(err), eventReasonFailedDelete)
 	}
+	return nil
+}
+
+func (a *Actuator) delete(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext) error {
+	l := log.FromContext(ctx).WithName("Actuator.Delete")
+
 	sc := a.serverClientFactory(mctx.token)
 
 	s, err := a.getServer(ctx, sc, *mctx)
@@ -324,14 +629,63 @@ func (a *Actuator) Delete(ctx context.Context, machine *machinev1beta1.Machine)
 
 	if s == nil {
 		l.Info("Machine to delete not found, skipping", "machine", machine.Name)
-		return nil
+		a.releaseAddressesFromRanges(machine)
+		return a.releaseAddressesFromPools(ctx, machine)
 	}
 
 	if err := sc.Delete(ctx, s.UUID); err != nil {
 		return fmt.Errorf("failed to delete server %q: %w", machine.Name, err)
 	}
+	a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonDeleted, "Deleted server %q", s.UUID)
 
-	return nil
+	a.releaseAddressesFromRanges(machine)
+	return a.releaseAddressesFromPools(ctx, machine)
+}
+
+// validateToken validates the cloudscale API token of the machine and records the result as the TokenValid condition in the provider status.
+// If the token is invalid, the condition is patched immediately and an error is returned.
+func (a *Actuator) validateToken(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext) error {
+	if a.tokenValidator == nil {
+		return nil
+	}
+
+	verr := a.tokenValidator(ctx, mctx.token)
+	if verr != nil && !errors.Is(verr, csclient.ErrInvalidToken) {
+		log.FromContext(ctx).WithName("Actuator.validateToken").Info("Unable to validate token, continuing", "error", mctx.redactError(verr).Error())
+		return nil
+	}
+
+	cond := metav1.Condition{
+		Type:               csv1beta1.TokenValidCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             conditionReasonTokenValid,
+		Message:            "The cloudscale API token was accepted by the API",
+		ObservedGeneration: machine.Generation,
+	}
+	if verr != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = conditionReasonTokenInvalid
+		cond.Message = mctx.redactError(verr).Error()
+	}
+	if err := setProviderStatusCondition(machine, cond); err != nil {
+		return fmt.Errorf("failed to set token condition on machine %q: %w", machine.Name, err)
+	}
+
+	if verr == nil {
+		return nil
+	}
+
+	a.eventRecorder.Eventf(machine, corev1.EventTypeWarning, eventReasonInvalidToken, "The cloudscale API token was rejected by the API")
+	if err := a.patchMachine(ctx, mctx.machine, machine); err != nil {
+		return fmt.Errorf("failed to patch machine %q: %w", machine.Name, err)
+	}
+	return fmt.Errorf("invalid token for machine %q: %w", machine.Name, verr)
+}
+
+// handleMachineError records a warning event with the given reason on the machine and returns the error unchanged.
+func (a *Actuator) handleMachineError(machine *machinev1beta1.Machine, err error, reason string) error {
+	a.eventRecorder.Eventf(machine, corev1.EventTypeWarning, reason, "%v", err)
+	return err
 }
 
 func (a *Actuator) getServer(ctx context.Context, sc cloudscale.ServerService, machineCtx machineContext) (*cloudscale.Server, error) {
@@ -383,20 +737,23 @@ func (a *Actuator) patchMachine(ctx context.Context, orig, updated *machinev1bet
 // ensureAntiAffinityServerGroupForKey ensures that a server group with less than 4 servers exists for the given key.
 // If such a server group exists, its UUID is returned.
 // If no such server group exists, a new server group is created and its UUID is returned.
-func (a *Actuator) ensureAntiAffinityServerGroupForKey(ctx context.Context, sgc cloudscale.ServerGroupService, zone, key string) (string, error) {
+// A ServerGroupSelected or ServerGroupCreated event is recorded on the machine.
+// Returns whether the server group was created.
+func (a *Actuator) ensureAntiAffinityServerGroupForKey(ctx context.Context, sgc cloudscale.ServerGroupService, machine *machinev1beta1.Machine, zone, key string) (string, bool, error) {
 	l := log.FromContext(ctx).WithName("Actuator.ensureAntiAffinityServerGroupForKey").WithValues("key", key, "zone", zone)
 	lookupKey := cloudscale.TagMap{antiAffinityTag: key}
 
 	sgs, err := sgc.List(ctx, cloudscale.WithTagFilter(lookupKey))
 	if err != nil {
-		return "", fmt.Errorf("failed to list server groups: %w", err)
+		return "", false, fmt.Errorf("failed to list server groups: %w", err)
 	}
 
 	for _, sg := range sgs {
 		if sg.Zone.Slug == zone {
 			if len(sg.Servers) < 4 {
 				l.Info("Found existing server group with less than 4 servers", "serverGroup", sg.UUID)
-				return sg.UUID, nil
+				a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonServerGroupSelected, "Selected server group %q for anti-affinity key %q", sg.UUID, key)
+				return sg.UUID, false, nil
 			}
 		}
 	}
@@ -413,33 +770,175 @@ func (a *Actuator) ensureAntiAffinityServerGroupForKey(ctx context.Context, sgc
 		Type: "anti-affinity",
 	})
 	if err != nil {
-		l.Error(err, "Failed to create server group")
-		return "", fmt.Errorf("failed to create server group: %w", err)
+		return "", false, fmt.Errorf("failed to create server group: %w", err)
+	}
+	a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonServerGroupCreated, "Created server group %q for anti-affinity key %q", sg.UUID, key)
+
+	return sg.UUID, true, nil
+}
+
+// ensureServerGroupsByName returns the UUIDs of the server groups with the given names in the zone.
+// Server groups managed by a CloudscaleServerGroup in the machine's namespace are only looked up, the controller owns them.
+// Missing server groups are created as anti-affinity groups tagged with ManagedServerGroupTag.
+// Returns a terminal error if a name matches more than one server group in the zone.
+// Also returns the UUIDs of the server groups created, even if ensuring a later name failed.
+func (a *Actuator) ensureServerGroupsByName(ctx context.Context, sgc cloudscale.ServerGroupService, machine *machinev1beta1.Machine, zone string, names []string) (uuids, created []string, err error) {
+	l := log.FromContext(ctx).WithName("Actuator.ensureServerGroupsByName").WithValues("zone", zone)
+
+	managed, err := a.managedServerGroups(ctx, machine.Namespace, zone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sgs []cloudscale.ServerGroup
+	if slices.ContainsFunc(names, func(name string) bool { _, ok := managed[name]; return !ok }) {
+		sgs, err = sgc.List(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list server groups: %w", err)
+		}
 	}
 
-	return sg.UUID, nil
+	uuids = make([]string, 0, len(names))
+	for _, name := range names {
+		if sg, ok := managed[name]; ok {
+			if sg.Status.UUID == "" {
+				return nil, created, fmt.Errorf("%w: server group %q of CloudscaleServerGroup %q has not been created yet: %w",
+					errServerGroupNotReady, name, sg.Name, &machinecontroller.RequeueAfterError{RequeueAfter: serverGroupNotReadyRequeueAfter})
+			}
+			uuids = append(uuids, sg.Status.UUID)
+			continue
+		}
+
+		var matches []string
+		for _, sg := range sgs {
+			if sg.Zone.Slug == zone && sg.Name == name {
+				matches = append(matches, sg.UUID)
+			}
+		}
+		if len(matches) > 0 {
+			uuid, err := singleMatch("server group", fmt.Sprintf("in zone %q with name %q", zone, name), matches)
+			if err != nil {
+				return nil, created, err
+			}
+			uuids = append(uuids, uuid)
+			continue
+		}
+
+		l.Info("No server group with name found, creating new server group", "name", name)
+		sg, err := sgc.Create(ctx, &cloudscale.ServerGroupRequest{
+			ZonalResourceRequest: cloudscale.ZonalResourceRequest{
+				Zone: zone,
+			},
+			TaggedResourceRequest: cloudscale.TaggedResourceRequest{
+				Tags: ptr.To(cloudscale.TagMap{ManagedServerGroupTag: "true"}),
+			},
+			Name: name,
+			Type: "anti-affinity",
+		})
+		if err != nil {
+			return nil, created, fmt.Errorf("failed to create server group %q: %w", name, err)
+		}
+		a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonServerGroupCreated, "Created server group %q with name %q", sg.UUID, name)
+		uuids = append(uuids, sg.UUID)
+		created = append(created, sg.UUID)
+	}
+	return uuids, created, nil
 }
 
-func updateMachineFromCloudscaleServer(machine *machinev1beta1.Machine, s cloudscale.Server) error {
+// managedServerGroups returns the CloudscaleServerGroups in the namespace for the zone by the name of their server group.
+// Returns an empty map if the CloudscaleServerGroup CRD is not installed.
+func (a *Actuator) managedServerGroups(ctx context.Context, namespace, zone string) (map[string]cloudscalev1beta1.CloudscaleServerGroup, error) {
+	var list cloudscalev1beta1.CloudscaleServerGroupList
+	if err := a.k8sClient.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+		if meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+			return map[string]cloudscalev1beta1.CloudscaleServerGroup{}, nil
+		}
+		return nil, fmt.Errorf("failed to list CloudscaleServerGroups: %w", err)
+	}
+
+	managed := make(map[string]cloudscalev1beta1.CloudscaleServerGroup, len(list.Items))
+	for _, sg := range list.Items {
+		if sg.Spec.Zone == zone && sg.DeletionTimestamp.IsZero() {
+			managed[sg.ServerGroupName()] = sg
+		}
+	}
+	return managed, nil
+}
+
+func updateMachineFromCloudscaleServer(machine *machinev1beta1.Machine, specInterfaces []csv1beta1.Interface, s cloudscale.Server) error {
 	if machine.Labels == nil {
 		machine.Labels = make(map[string]string)
 	}
 	machine.Labels[machinecontroller.MachineInstanceTypeLabelName] = s.Flavor.Slug
-	machine.Labels[machinecontroller.MachineRegionLabelName] = strings.TrimRightFunc(s.Zone.Slug, func(r rune) bool {
-		return r >= '0' && r <= '9'
-	})
+	machine.Labels[machinecontroller.MachineRegionLabelName] = regionFromZone(s.Zone.Slug)
 	machine.Labels[machinecontroller.MachineAZLabelName] = s.Zone.Slug
 
 	machine.Spec.ProviderID = ptr.To(formatProviderID(s.UUID))
-	machine.Status.Addresses = machineAddressesFromCloudscaleServer(s)
-	status := providerStatusFromCloudscaleServer(s)
-	rawStatus, err := csv1beta1.RawExtensionFromProviderStatus(&status)
-	if err != nil {
-		return fmt.Errorf("failed to create raw extension from provider status: %w", err)
+	// The addresses are only complete once the server is ready.
+	// The cluster-machine-approver must never see a partial list of addresses.
+	if serverReady(s, specInterfaces) {
+		machine.Status.Addresses = machineAddressesFromCloudscaleServer(s)
 	}
-	machine.Status.ProviderStatus = rawStatus
+	return updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+		updateProviderStatusFromCloudscaleServer(status, s)
+	})
+}
 
-	return nil
+// serverChanging is the status of a server while it is being created or changed.
+// The cloudscale SDK only defines constants for running and stopped servers.
+const serverChanging = "changing"
+
+// serverReady returns true if the server is not changing and all its interfaces that should have addresses have them.
+// Stopped servers are ready, they keep their addresses.
+// The interfaces of the server are matched to the interfaces of the spec by their index.
+func serverReady(s cloudscale.Server, specInterfaces []csv1beta1.Interface) bool {
+	if s.Status == serverChanging {
+		return false
+	}
+	for i, iface := range s.Interfaces {
+		if len(iface.Addresses) == 0 && interfaceRequiresAddresses(iface, i, specInterfaces) {
+			return false
+		}
+	}
+	return true
+}
+
+// interfaceRequiresAddresses returns true if the i-th interface of a server gets addresses.
+// Public interfaces always get addresses.
+// Private interfaces only get addresses if the spec asks for them, a private network without a subnet assigns no address.
+func interfaceRequiresAddresses(iface cloudscale.Interface, i int, specInterfaces []csv1beta1.Interface) bool {
+	if iface.Type == "public" {
+		return true
+	}
+	if i >= len(specInterfaces) || specInterfaces[i].Type != csv1beta1.InterfaceTypePrivate {
+		return false
+	}
+	si := specInterfaces[i]
+	return len(si.Addresses) > 0 || len(si.AddressesFromPools) > 0 || si.AddressFromRange != nil
+}
+
+// errServerNotReady is wrapped by the errors returned from Create and Update while the server of the machine is not ready.
+// The machine is requeued without recording a failure event.
+var errServerNotReady = errors.New("server is not ready")
+
+// errServerGroupNotReady is wrapped by the errors returned from Create while a CloudscaleServerGroup the machine references has not created its server group yet.
+// The machine is requeued without recording a failure event.
+var errServerGroupNotReady = errors.New("server group is not ready")
+
+// isExpectedRequeue returns true if the error is an expected requeue while waiting for the server, its server groups, its addresses or the quota.
+// Expected requeues are not worth a failure event.
+func isExpectedRequeue(err error) bool {
+	return errors.Is(err, errServerNotReady) || errors.Is(err, errServerGroupNotReady) || errors.Is(err, errIPAddressClaimsNotBound) || errors.Is(err, errQuotaExceeded)
+}
+
+// serverNotReadyError returns an error wrapping errServerNotReady and a machinecontroller.RequeueAfterError if the server is not ready.
+// Returns nil if the server is ready.
+func serverNotReadyError(machine *machinev1beta1.Machine, specInterfaces []csv1beta1.Interface, s cloudscale.Server) error {
+	if serverReady(s, specInterfaces) {
+		return nil
+	}
+	return fmt.Errorf("%w: server %q of machine %q is %s, waiting for it to finish changing and to have addresses: %w",
+		errServerNotReady, s.UUID, machine.Name, s.Status, &machinecontroller.RequeueAfterError{RequeueAfter: serverNotReadyRequeueAfter})
 }
 
 func machineAddressesFromCloudscaleServer(s cloudscale.Server) []corev1.NodeAddress {
@@ -489,11 +988,35 @@ func formatProviderID(uuid string) string {
 	return fmt.Sprintf("cloudscale://%s", uuid)
 }
 
-func providerStatusFromCloudscaleServer(s cloudscale.Server) csv1beta1.CloudscaleMachineProviderStatus {
-	return csv1beta1.CloudscaleMachineProviderStatus{
-		InstanceID: s.UUID,
-		Status:     s.Status,
+// updateProviderStatusFromCloudscaleServer updates the server fields of the provider status.
+// Conditions and other fields set by the actuator are kept.
+func updateProviderStatusFromCloudscaleServer(status *csv1beta1.CloudscaleMachineProviderStatus, s cloudscale.Server) {
+	status.InstanceID = s.UUID
+	status.Status = s.Status
+	status.Zone = s.Zone.Slug
+	status.Flavor = s.Flavor.Slug
+}
+
+// setProviderStatusCondition sets the given condition in the provider status of the machine.
+func setProviderStatusCondition(machine *machinev1beta1.Machine, cond metav1.Condition) error {
+	return updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+		meta.SetStatusCondition(&status.Conditions, cond)
+	})
+}
+
+// updateProviderStatus decodes the provider status of the machine, applies the given function to it, and encodes it again.
+func updateProviderStatus(machine *machinev1beta1.Machine, update func(status *csv1beta1.CloudscaleMachineProviderStatus)) error {
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status from machine: %w", err)
 	}
+	update(status)
+	rawStatus, err := csv1beta1.RawExtensionFromProviderStatus(status)
+	if err != nil {
+		return fmt.Errorf("failed to create raw extension from provider status: %w", err)
+	}
+	machine.Status.ProviderStatus = rawStatus
+	return nil
 }
 
 func cloudscaleServerInterfacesFromProviderSpecInterfaces(interfaces []csv1beta1.Interface) *[]cloudscale.InterfaceRequest {
@@ -537,11 +1060,32 @@ type machineContext struct {
 	clusterId string
 	spec      csv1beta1.CloudscaleMachineProviderSpec
 	token     string
+
+	// serverGroups are the UUIDs of the server groups the server is added to.
+	// Set during create before the user data is rendered.
+	serverGroups []string
+	// createdServerGroups are the UUIDs of the server groups created for the zone tried last.
+	// They are deleted if the server can't be created in the zone for capacity reasons.
+	createdServerGroups []string
+	// flavors are the flavor and the FlavorFallbacks of the spec fitting into the quota, in the order they are tried.
+	// Set during create before anything is created for the machine.
+	flavors []string
+	// userDataSecretPolicyApplied is true if the UserDataSecretPolicy was applied to secrets matching UserDataSecretSelector or to Jsonnet library secrets.
+	userDataSecretPolicyApplied bool
+	// blockedUserDataSecrets are the names of the secrets matching UserDataSecretSelector and of the Jsonnet library secrets blocked by the UserDataSecretPolicy.
+	// Set when the user data is rendered.
+	blockedUserDataSecrets []string
+	// userDataSecretResourceVersion is the resource version of the UserDataSecret the user data was rendered from.
+	// Set when the user data is rendered.
+	userDataSecretResourceVersion string
+	// userDataSourcesVersion is the userDataSourcesVersion of the objects the user data was rendered from.
+	userDataSourcesVersion string
+
+	// sensitiveValues are redacted from errors returned by the actuator.
+	sensitiveValues []string
 }
 
 func (a *Actuator) getMachineContext(ctx context.Context, machine *machinev1beta1.Machine) (*machineContext, error) {
-	const tokenKey = "token"
-
 	origMachine := machine.DeepCopy()
 
 	clusterId, ok := machine.Labels[machineClusterIDLabelName]
@@ -554,16 +1098,16 @@ func (a *Actuator) getMachineContext(ctx context.Context, machine *machinev1beta
 		return nil, fmt.Errorf("failed to get provider spec from machine %q: %w", machine.Name, err)
 	}
 
-	token := a.defaultCloudscaleAPIToken
+	token := *a.defaultCloudscaleAPIToken.Load()
 	if spec.TokenSecret != nil {
 		secret := &corev1.Secret{}
 		if err := a.k8sClient.Get(ctx, client.ObjectKey{Name: spec.TokenSecret.Name, Namespace: machine.Namespace}, secret); err != nil {
 			return nil, fmt.Errorf("failed to get secret %q: %w", spec.TokenSecret.Name, err)
 		}
 
-		tb, ok := secret.Data[tokenKey]
+		tb, ok := secret.Data[TokenSecretKey]
 		if !ok {
-			return nil, fmt.Errorf("token key %q not found in secret %q", tokenKey, spec.TokenSecret.Name)
+			return nil, fmt.Errorf("token key %q not found in secret %q", TokenSecretKey, spec.TokenSecret.Name)
 		}
 
 		token = string(tb)
@@ -574,81 +1118,7 @@ func (a *Actuator) getMachineContext(ctx context.Context, machine *machinev1beta
 		clusterId: clusterId,
 		spec:      *spec,
 		token:     token,
-	}, nil
-}
-
-func (a *Actuator) loadAndRenderUserDataSecret(ctx context.Context, mctx *machineContext) (string, error) {
-	const userDataKey = "userData"
-
-	if mctx.spec.UserDataSecret == nil {
-		return "", nil
-	}
-
-	secret := &corev1.Secret{}
-	if err := a.k8sClient.Get(ctx, client.ObjectKey{Name: mctx.spec.UserDataSecret.Name, Namespace: mctx.machine.Namespace}, secret); err != nil {
-		return "", fmt.Errorf("failed to get secret %q: %w", mctx.spec.UserDataSecret.Name, err)
-	}
-
-	userDataRaw, ok := secret.Data[userDataKey]
-	if !ok {
-		return "", fmt.Errorf("%q key not found in secret %q", userDataKey, mctx.spec.UserDataSecret.Name)
-	}
-	userData := string(userDataRaw)
-
-	if userData == "" {
-		return "", nil
-	}
-
-	data := make(map[string]string, len(secret.Data))
-	for k, v := range secret.Data {
-		data[k] = string(v)
-	}
 
-	var userDataSecrets corev1.SecretList
-	if mctx.spec.UserDataSecretSelector != nil {
-		sel, err := metav1.LabelSelectorAsSelector(mctx.spec.UserDataSecretSelector)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse UserDataSecretSelector: %w", err)
-		}
-		if err := a.k8sClient.List(
-			ctx,
-			&userDataSecrets,
-			client.InNamespace(mctx.machine.Namespace),
-			client.MatchingLabelsSelector{Selector: sel},
-		); err != nil {
-			return "", fmt.Errorf("failed to list secrets in namespace %q: %w", mctx.machine.Namespace, err)
-		}
-	}
-
-	jvm, err := jsonnetVMWithContext(mctx.machine, data, userDataSecrets)
-	if err != nil {
-		return "", fmt.Errorf("userData: failed to create jsonnet VM: %w", err)
-	}
-	ud, err := jvm.EvaluateAnonymousSnippet("context", userData)
-	if err != nil {
-		return "", fmt.Errorf("userData: failed to evaluate jsonnet: %w", err)
-	}
-
-	var compacted bytes.Buffer
-	if err := json.Compact(&compacted, []byte(ud)); err != nil {
-		return "", fmt.Errorf("userData: failed to compact json: %w", err)
-	}
-
-	return compacted.String(), nil
-}
-
-func jsonnetVMWithContext(machine *machinev1beta1.Machine, data map[string]string, userDataSecrets corev1.SecretList) (*jsonnet.VM, error) {
-	jcr, err := json.Marshal(map[string]any{
-		"machine": machine,
-		"data":    data,
-		"secrets": userDataSecrets.Items,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("unable to marshal jsonnet context: %w", err)
-	}
-	jvm := jsonnet.MakeVM()
-	jvm.ExtCode("context", string(jcr))
-	// Don't allow imports
-	jvm.Importer(&jsonnet.MemoryImporter{})
-	return jvm, nil
+		sensitiveValues: append([]string{token}, spec.SSHKeys...),
+	}, nil
 }
@@ -2,6 +2,7 @@ package machine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"maps"
 	"net/http/httptest"
@@ -9,20 +10,26 @@ import (
 
 	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine/csmock"
 	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	configv1 "github.com/openshift/api/config/v1"
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
+	ipamv1beta1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	cloudscalev1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/csclient"
 )
 
 func Test_Actuator_Create_ComplexMachineE2E(t *testing.T) {
@@ -127,6 +134,8 @@ func Test_Actuator_Create_ComplexMachineE2E(t *testing.T) {
 	sgs := csmock.NewMockServerGroupService(ctrl)
 	vs := csmock.NewMockVolumeService(ctrl)
 	actuator := newActuator(c, ss, sgs, vs)
+	recorder := record.NewFakeRecorder(10)
+	actuator.eventRecorder = recorder
 
 	sgs.EXPECT().List(
 		gomock.Any(),
@@ -187,6 +196,8 @@ func Test_Actuator_Create_ComplexMachineE2E(t *testing.T) {
 		}),
 	).DoAndReturn(cloudscaleServerFromServerRequest(func(s *cloudscale.Server) {
 		s.UUID = "created-server-uuid"
+		// The cloudscale API returns the server before its interfaces are fully set up
+		s.Status = "changing"
 		s.TaggedResource = cloudscale.TaggedResource{
 			Tags: cloudscale.TagMap{
 				machineNameTag:      machine.Name,
@@ -210,10 +221,42 @@ func Test_Actuator_Create_ComplexMachineE2E(t *testing.T) {
 		},
 	})).Return(nil)
 
-	require.NoError(t, actuator.Create(ctx, machine))
+	err := actuator.Create(ctx, machine)
+	var requeueErr *machinecontroller.RequeueAfterError
+	require.True(t, errors.As(err, &requeueErr), "Create should requeue until the server is running, got %v", err)
 
 	updatedMachine := &machinev1beta1.Machine{}
 	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(machine), updatedMachine))
+	assert.Empty(t, updatedMachine.Status.Addresses, "addresses of a changing server must not be set")
+
+	// The machine controller calls Update once the server exists
+	ss.EXPECT().List(gomock.Any(), gomock.Any()).Return([]cloudscale.Server{{
+		UUID:   "created-server-uuid",
+		Name:   "app-test.cluster.example.com",
+		Status: cloudscale.ServerRunning,
+		TaggedResource: cloudscale.TaggedResource{
+			Tags: buildServerTags(machine.Name, clusterID, providerSpec.Tags),
+		},
+		ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "rma1"}},
+		Flavor:        cloudscale.Flavor{Slug: "flex-16-4"},
+		Volumes:       []cloudscale.VolumeStub{{UUID: "root-volume-uuid"}},
+		ServerGroups:  []cloudscale.ServerGroupStub{{UUID: "created-server-group-uuid"}},
+		Interfaces: []cloudscale.Interface{
+			{
+				Type:      "private",
+				Network:   cloudscale.NetworkStub{UUID: "6ad814b4-587f-44d2-96a1-38750c9a21d5"},
+				Addresses: []cloudscale.Address{{Address: "172.10.11.12"}},
+			}, {
+				Type:      "public",
+				Addresses: []cloudscale.Address{{Address: "203.0.113.10"}},
+			},
+		},
+	}}, nil)
+	vs.EXPECT().Get(gomock.Any(), "root-volume-uuid").Return(&cloudscale.Volume{
+		TaggedResource: cloudscale.TaggedResource{Tags: rootVolumeTags},
+	}, nil)
+	require.NoError(t, actuator.Update(ctx, updatedMachine))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(machine), updatedMachine))
 	if assert.NotNil(t, updatedMachine.Spec.ProviderID) {
 		assert.Equal(t, "cloudscale://created-server-uuid", *updatedMachine.Spec.ProviderID)
 	}
@@ -225,6 +268,14 @@ func Test_Actuator_Create_ComplexMachineE2E(t *testing.T) {
 		assert.Equal(t, "rma", updatedMachine.Labels[machinecontroller.MachineRegionLabelName])
 	}
 
+	status, err := csv1beta1.ProviderStatusFromRawExtension(updatedMachine.Status.ProviderStatus)
+	require.NoError(t, err)
+	if cond := meta.FindStatusCondition(status.Conditions, csv1beta1.UserDataSecretsAllowedCondition); assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	}
+	assert.Equal(t, userDataHash("{\"ca\":\"CADATA\",\"udsecrets\":[[\"user-data-managed\",{\"ignition\":{}}]]}"), status.UserDataHash)
+	assert.NotEmpty(t, status.UserDataSecretResourceVersion)
+
 	// https://github.com/openshift/cluster-machine-approver?tab=readme-ov-file#requirements-for-cluster-api-providers
 	// * A Machine must have a NodeInternalDNS set in Status.Addresses that matches the name of the Node.
 	//   The NodeInternalDNS entry must be present, even before the Node resource is created.
@@ -248,7 +299,17 @@ func Test_Actuator_Create_ComplexMachineE2E(t *testing.T) {
 			Type:    corev1.NodeInternalIP,
 			Address: "172.10.11.12",
 		},
+		{
+			Type:    corev1.NodeExternalIP,
+			Address: "203.0.113.10",
+		},
 	}, updatedMachine.Status.Addresses)
+
+	assert.Equal(t, []string{
+		`Normal ServerGroupCreated Created server group "created-server-group-uuid" for anti-affinity key "app"`,
+		`Normal Created Created server "app-test.cluster.example.com" with UUID "created-server-uuid"`,
+		`Normal RootVolumeTagged Tagged root volume "root-volume-uuid"`,
+	}, drainEvents(recorder))
 }
 
 func Test_Actuator_Create_AntiAffinityPools(t *testing.T) {
@@ -303,7 +364,7 @@ func Test_Actuator_Create_AntiAffinityPools(t *testing.T) {
 						SSHKeys:      []string{},
 						Zone:         zone,
 					}),
-				).Return(&cloudscale.Server{}, nil)
+				).Return(&cloudscale.Server{Status: cloudscale.ServerRunning}, nil)
 			},
 		},
 		{
@@ -341,7 +402,7 @@ func Test_Actuator_Create_AntiAffinityPools(t *testing.T) {
 						SSHKeys:      []string{},
 						Zone:         zone,
 					}),
-				).Return(&cloudscale.Server{}, nil)
+				).Return(&cloudscale.Server{Status: cloudscale.ServerRunning}, nil)
 			},
 		},
 		{
@@ -396,7 +457,7 @@ func Test_Actuator_Create_AntiAffinityPools(t *testing.T) {
 						SSHKeys:      []string{},
 						Zone:         zone,
 					}),
-				).Return(&cloudscale.Server{}, nil)
+				).Return(&cloudscale.Server{Status: cloudscale.ServerRunning}, nil)
 			},
 		},
 		{
@@ -451,7 +512,7 @@ func Test_Actuator_Create_AntiAffinityPools(t *testing.T) {
 						SSHKeys:      []string{},
 						Zone:         zone,
 					}),
-				).Return(&cloudscale.Server{}, nil)
+				).Return(&cloudscale.Server{Status: cloudscale.ServerRunning}, nil)
 			},
 		},
 	}
@@ -500,6 +561,277 @@ func Test_Actuator_Create_AntiAffinityPools(t *testing.T) {
 	}
 }
 
+func Test_Actuator_Create_ServerGroupNames(t *testing.T) {
+	const zone = "rma1"
+	const clusterID = "cluster-id"
+
+	inZone := func(uuid, name, zone string) cloudscale.ServerGroup {
+		return cloudscale.ServerGroup{
+			UUID:          uuid,
+			Name:          name,
+			ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: zone}},
+		}
+	}
+
+	tcs := []struct {
+		name          string
+		serverGroups  []runtime.Object
+		apiMock       func(*testing.T, *machinev1beta1.Machine, *csmock.MockServerService, *csmock.MockServerGroupService)
+		expectedErr   string
+		expectRequeue bool
+	}{
+		{
+			name: "existing and missing server groups",
+			apiMock: func(t *testing.T, machine *machinev1beta1.Machine, ss *csmock.MockServerService, sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().List(gomock.Any()).Return([]cloudscale.ServerGroup{
+					inZone("db-lpg1-uuid", "db", "lpg1"),
+					inZone("db-uuid", "db", zone),
+				}, nil)
+				sgs.EXPECT().Create(
+					gomock.Any(),
+					newDeepEqualMatcher(t, &cloudscale.ServerGroupRequest{
+						Name: "web",
+						ZonalResourceRequest: cloudscale.ZonalResourceRequest{
+							Zone: zone,
+						},
+						TaggedResourceRequest: cloudscale.TaggedResourceRequest{
+							Tags: &cloudscale.TagMap{
+								ManagedServerGroupTag: "true",
+							},
+						},
+						Type: "anti-affinity",
+					}),
+				).Return(&cloudscale.ServerGroup{UUID: "web-uuid"}, nil)
+				ss.EXPECT().Create(
+					gomock.Any(),
+					newDeepEqualMatcher(t, &cloudscale.ServerRequest{
+						Name: machine.Name,
+						ZonalResourceRequest: cloudscale.ZonalResourceRequest{
+							Zone: zone,
+						},
+						TaggedResourceRequest: cloudscale.TaggedResourceRequest{
+							Tags: ptr.To(cloudscale.TagMap{
+								machineNameTag:      machine.Name,
+								machineClusterIDTag: clusterID,
+							}),
+						},
+						ServerGroups: []string{"static-uuid", "db-uuid", "web-uuid"},
+						SSHKeys:      []string{},
+						Zone:         zone,
+					}),
+				).Return(&cloudscale.Server{Status: cloudscale.ServerRunning}, nil)
+			},
+		},
+		{
+			name: "ambiguous server group name",
+			apiMock: func(t *testing.T, machine *machinev1beta1.Machine, ss *csmock.MockServerService, sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().List(gomock.Any()).Return([]cloudscale.ServerGroup{
+					inZone("db-uuid", "db", zone),
+					inZone("db-2-uuid", "db", zone),
+				}, nil)
+			},
+			expectedErr: `2 server groups found in zone "rma1" with name "db"`,
+		},
+		{
+			name: "server groups of CloudscaleServerGroups are not created",
+			serverGroups: []runtime.Object{
+				&cloudscalev1beta1.CloudscaleServerGroup{
+					ObjectMeta: metav1.ObjectMeta{Name: "web"},
+					Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: zone},
+					Status:     cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "web-uuid"},
+				},
+				&cloudscalev1beta1.CloudscaleServerGroup{
+					ObjectMeta: metav1.ObjectMeta{Name: "database"},
+					Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: zone, Name: "db"},
+					Status:     cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "db-uuid"},
+				},
+			},
+			apiMock: func(t *testing.T, machine *machinev1beta1.Machine, ss *csmock.MockServerService, sgs *csmock.MockServerGroupService) {
+				ss.EXPECT().Create(
+					gomock.Any(),
+					newDeepEqualMatcher(t, &cloudscale.ServerRequest{
+						Name: machine.Name,
+						ZonalResourceRequest: cloudscale.ZonalResourceRequest{
+							Zone: zone,
+						},
+						TaggedResourceRequest: cloudscale.TaggedResourceRequest{
+							Tags: ptr.To(cloudscale.TagMap{
+								machineNameTag:      machine.Name,
+								machineClusterIDTag: clusterID,
+							}),
+						},
+						ServerGroups: []string{"static-uuid", "db-uuid", "web-uuid"},
+						SSHKeys:      []string{},
+						Zone:         zone,
+					}),
+				).Return(&cloudscale.Server{Status: cloudscale.ServerRunning}, nil)
+			},
+		},
+		{
+			name: "waits for server group of CloudscaleServerGroup",
+			serverGroups: []runtime.Object{
+				&cloudscalev1beta1.CloudscaleServerGroup{
+					ObjectMeta: metav1.ObjectMeta{Name: "web"},
+					Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: zone},
+				},
+				&cloudscalev1beta1.CloudscaleServerGroup{
+					ObjectMeta: metav1.ObjectMeta{Name: "db"},
+					Spec:       cloudscalev1beta1.CloudscaleServerGroupSpec{Zone: "lpg1"},
+					Status:     cloudscalev1beta1.CloudscaleServerGroupStatus{UUID: "db-lpg1-uuid"},
+				},
+			},
+			apiMock: func(t *testing.T, machine *machinev1beta1.Machine, ss *csmock.MockServerService, sgs *csmock.MockServerGroupService) {
+				sgs.EXPECT().List(gomock.Any()).Return([]cloudscale.ServerGroup{
+					inZone("db-uuid", "db", zone),
+				}, nil)
+			},
+			expectedErr:   `server group "web" of CloudscaleServerGroup "web" has not been created yet`,
+			expectRequeue: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app-test",
+					Labels: map[string]string{
+						machineClusterIDLabelName: clusterID,
+					},
+				},
+			}
+			providerSpec := csv1beta1.CloudscaleMachineProviderSpec{
+				Zone:             zone,
+				ServerGroups:     []string{"static-uuid"},
+				ServerGroupNames: []string{"db", "web"},
+			}
+			setProviderSpecOnMachine(t, machine, &providerSpec)
+
+			c := newFakeClient(t, append(tc.serverGroups, machine)...)
+			ss := csmock.NewMockServerService(ctrl)
+			sgs := csmock.NewMockServerGroupService(ctrl)
+			actuator := newActuator(c, ss, sgs, nil)
+
+			tc.apiMock(t, machine, ss, sgs)
+
+			err := actuator.Create(t.Context(), machine)
+			if tc.expectRequeue {
+				require.ErrorContains(t, err, tc.expectedErr)
+				assert.ErrorIs(t, err, errServerGroupNotReady)
+				var rerr *machinecontroller.RequeueAfterError
+				assert.ErrorAs(t, err, &rerr)
+				return
+			}
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				var merr *machinecontroller.MachineError
+				assert.True(t, errors.As(err, &merr), "ambiguous names should be a terminal error")
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_NewActuator_NilEventRecorder(t *testing.T) {
+	machine := &machinev1beta1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "app-test"}}
+	actuator := NewActuator(ActuatorParams{K8sClient: newFakeClient(t, machine)})
+
+	// The machine has no cluster ID label, the failure is recorded as an event.
+	require.NotPanics(t, func() {
+		assert.ErrorContains(t, actuator.Create(t.Context(), machine), "cluster ID label")
+	})
+}
+
+func Test_Actuator_Create_TokenValidation(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name          string
+		validationErr error
+		apiMock       func(*csmock.MockServerService)
+		wantErr       string
+		wantCondition metav1.ConditionStatus
+	}{
+		{
+			name: "valid token",
+			apiMock: func(ss *csmock.MockServerService) {
+				ss.EXPECT().Create(gomock.Any(), gomock.Any()).Return(&cloudscale.Server{UUID: "server-uuid", Status: cloudscale.ServerRunning}, nil)
+			},
+			wantCondition: metav1.ConditionTrue,
+		},
+		{
+			name:          "invalid token",
+			validationErr: fmt.Errorf("%w: Invalid token.", csclient.ErrInvalidToken),
+			apiMock:       func(ss *csmock.MockServerService) {},
+			wantErr:       "cloudscale API token is invalid",
+			wantCondition: metav1.ConditionFalse,
+		},
+		{
+			name:          "validation not possible",
+			validationErr: fmt.Errorf("connection refused"),
+			apiMock: func(ss *csmock.MockServerService) {
+				ss.EXPECT().Create(gomock.Any(), gomock.Any()).Return(&cloudscale.Server{UUID: "server-uuid", Status: cloudscale.ServerRunning}, nil)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app-test",
+					Labels: map[string]string{
+						machineClusterIDLabelName: "cluster-id",
+					},
+				},
+			}
+			setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{Zone: "rma1"})
+
+			c := newFakeClient(t, machine)
+			ss := csmock.NewMockServerService(ctrl)
+			actuator := newActuator(c, ss, nil, nil)
+			actuator.tokenValidator = func(context.Context, string) error {
+				return tc.validationErr
+			}
+
+			tc.apiMock(ss)
+
+			err := actuator.Create(ctx, machine)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			var updatedMachine machinev1beta1.Machine
+			require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(machine), &updatedMachine))
+			status, err := csv1beta1.ProviderStatusFromRawExtension(updatedMachine.Status.ProviderStatus)
+			require.NoError(t, err)
+			cond := meta.FindStatusCondition(status.Conditions, csv1beta1.TokenValidCondition)
+			if tc.wantCondition == "" {
+				assert.Nil(t, cond)
+				return
+			}
+			if assert.NotNil(t, cond) {
+				assert.Equal(t, tc.wantCondition, cond.Status)
+			}
+		})
+	}
+}
+
 func Test_Actuator_Exists(t *testing.T) {
 	t.Parallel()
 	const clusterID = "cluster-id"
@@ -592,6 +924,35 @@ func Test_Actuator_Exists(t *testing.T) {
 	}
 }
 
+func Test_Actuator_SetDefaultCloudscaleAPIToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app-test",
+			Labels: map[string]string{
+				machineClusterIDLabelName: "cluster-id",
+			},
+		},
+	}
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{})
+
+	actuator := newActuator(newFakeClient(t, machine), nil, nil, nil)
+	actuator.SetDefaultCloudscaleAPIToken("initial-token")
+
+	mctx, err := actuator.getMachineContext(ctx, machine)
+	require.NoError(t, err)
+	assert.Equal(t, "initial-token", mctx.token)
+
+	actuator.SetDefaultCloudscaleAPIToken("rotated-token")
+
+	mctx, err = actuator.getMachineContext(ctx, machine)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated-token", mctx.token)
+}
+
 func Test_Actuator_Update(t *testing.T) {
 	type testCase struct {
 		name string
@@ -705,7 +1066,8 @@ func Test_Actuator_Update(t *testing.T) {
 					machineNameTag: machine.Name,
 				},
 			}).Return([]cloudscale.Server{{
-				UUID: "machine-uuid",
+				UUID:   "machine-uuid",
+				Status: cloudscale.ServerRunning,
 				TaggedResource: cloudscale.TaggedResource{
 					Tags: serverTagMap(tc.haveServerTags),
 				},
@@ -748,6 +1110,65 @@ func Test_Actuator_Update(t *testing.T) {
 	}
 }
 
+func Test_Actuator_Update_ExpectedRequeueNoFailureEvent(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	const clusterID = "cluster-id"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-test",
+			Namespace: "openshift-machine-api",
+			UID:       "machine-uid",
+			Labels: map[string]string{
+				machineClusterIDLabelName: clusterID,
+			},
+		},
+	}
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{
+		Interfaces: []csv1beta1.Interface{
+			{Type: csv1beta1.InterfaceTypePublic},
+			{
+				Type:        csv1beta1.InterfaceTypePrivate,
+				NetworkUUID: "net-uuid",
+				AddressesFromPools: []machinev1beta1.AddressesFromPool{{
+					Group:    "ipam.cluster.x-k8s.io",
+					Resource: "InClusterIPPool",
+					Name:     "private",
+				}},
+			},
+		},
+	})
+
+	c := newFakeClient(t, machine)
+	ss := csmock.NewMockServerService(ctrl)
+	vs := csmock.NewMockVolumeService(ctrl)
+	actuator := newActuator(c, ss, nil, vs)
+	actuator.reconcileInterfaces = true
+
+	ss.EXPECT().List(ctx, gomock.Any()).Return([]cloudscale.Server{{
+		UUID:   "machine-uuid",
+		Status: cloudscale.ServerRunning,
+		TaggedResource: cloudscale.TaggedResource{
+			Tags: cloudscale.TagMap(buildServerTags(machine.Name, clusterID, nil)),
+		},
+		Volumes:    []cloudscale.VolumeStub{{UUID: "root-volume-uuid"}},
+		Interfaces: []cloudscale.Interface{{Type: "public", Addresses: []cloudscale.Address{{Address: "203.0.113.5"}}}},
+	}}, nil)
+	vs.EXPECT().Get(gomock.Any(), "root-volume-uuid").Return(&cloudscale.Volume{}, nil)
+
+	err := actuator.Update(ctx, machine)
+	require.ErrorIs(t, err, errIPAddressClaimsNotBound)
+	var requeue *machinecontroller.RequeueAfterError
+	require.True(t, errors.As(err, &requeue), "expected RequeueAfterError, got %v", err)
+	assert.Empty(t, drainEvents(actuator.eventRecorder.(*record.FakeRecorder)), "waiting for the claims should not be reported as a failure")
+}
+
 func Test_Actuator_Delete(t *testing.T) {
 	t.Parallel()
 
@@ -837,6 +1258,112 @@ func Test_Actuator_Delete(t *testing.T) {
 	}
 }
 
+func Test_serverNotReadyError(t *testing.T) {
+	machine := &machinev1beta1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "app-test"}}
+	withAddress := cloudscale.Interface{Type: "public", Addresses: []cloudscale.Address{{Address: "203.0.113.10"}}}
+
+	private := csv1beta1.Interface{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "network-uuid"}
+	privateWithAddress := csv1beta1.Interface{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "network-uuid", Addresses: []csv1beta1.Address{{SubnetCIDR: "10.0.0.0/24"}}}
+
+	tcs := []struct {
+		name           string
+		specInterfaces []csv1beta1.Interface
+		server         cloudscale.Server
+		ready          bool
+	}{
+		{
+			name:   "running with addresses",
+			server: cloudscale.Server{Status: cloudscale.ServerRunning, Interfaces: []cloudscale.Interface{withAddress}},
+			ready:  true,
+		},
+		{
+			name:   "stopped with addresses",
+			server: cloudscale.Server{Status: cloudscale.ServerStopped, Interfaces: []cloudscale.Interface{withAddress}},
+			ready:  true,
+		},
+		{
+			name:   "changing",
+			server: cloudscale.Server{Status: "changing", Interfaces: []cloudscale.Interface{withAddress}},
+		},
+		{
+			name:   "public interface without addresses",
+			server: cloudscale.Server{Status: cloudscale.ServerRunning, Interfaces: []cloudscale.Interface{{Type: "public"}}},
+		},
+		{
+			name:           "private interface without requested addresses",
+			specInterfaces: []csv1beta1.Interface{{Type: csv1beta1.InterfaceTypePublic}, private},
+			server:         cloudscale.Server{Status: cloudscale.ServerRunning, Interfaces: []cloudscale.Interface{withAddress, {Type: "private"}}},
+			ready:          true,
+		},
+		{
+			name:           "private interface missing requested addresses",
+			specInterfaces: []csv1beta1.Interface{{Type: csv1beta1.InterfaceTypePublic}, privateWithAddress},
+			server:         cloudscale.Server{Status: cloudscale.ServerRunning, Interfaces: []cloudscale.Interface{withAddress, {Type: "private"}}},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := serverNotReadyError(machine, tc.specInterfaces, tc.server)
+			if tc.ready {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, errServerNotReady)
+			var requeueErr *machinecontroller.RequeueAfterError
+			require.True(t, errors.As(err, &requeueErr))
+			assert.Equal(t, serverNotReadyRequeueAfter, requeueErr.RequeueAfter)
+		})
+	}
+}
+
+func Test_Actuator_Delete_FailureEvent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	const clusterID = "cluster-id"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app-test",
+			Labels: map[string]string{
+				machineClusterIDLabelName: clusterID,
+			},
+		},
+	}
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{})
+
+	c := newFakeClient(t, machine)
+	ss := csmock.NewMockServerService(ctrl)
+	actuator := newActuator(c, ss, nil, nil)
+	recorder := record.NewFakeRecorder(10)
+	actuator.eventRecorder = recorder
+
+	ss.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("api unavailable"))
+
+	require.ErrorContains(t, actuator.Delete(ctx, machine), "api unavailable")
+	assert.Equal(t, []string{
+		`Warning FailedDelete failed to get server "app-test": failed to list servers: api unavailable`,
+	}, drainEvents(recorder))
+}
+
+// drainEvents returns all events currently buffered in the fake recorder.
+func drainEvents(r *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case e := <-r.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
 // cloudscaleServerFromServerRequest returns a function that creates a cloudscale.Server from a cloudscale.ServerRequest
 // The returned server can be modified by the callback function before being returned.
 func cloudscaleServerFromServerRequest(cb func(*cloudscale.Server)) func(_ context.Context, req *cloudscale.ServerRequest) (*cloudscale.Server, error) {
@@ -962,6 +1489,7 @@ func setProviderSpecOnMachine(t *testing.T, machine *machinev1beta1.Machine, pro
 func newActuator(c client.Client, ss cloudscale.ServerService, sgs cloudscale.ServerGroupService, vs cloudscale.VolumeService) *Actuator {
 	return NewActuator(ActuatorParams{
 		K8sClient:                 c,
+		EventRecorder:             record.NewFakeRecorder(100),
 		DefaultCloudscaleAPIToken: "",
 		ServerClientFactory: func(token string) cloudscale.ServerService {
 			return ss
@@ -984,6 +1512,9 @@ var testScheme = func() *runtime.Scheme {
 	scheme := runtime.NewScheme()
 	must(clientgoscheme.AddToScheme(scheme))
 	must(machinev1beta1.AddToScheme(scheme))
+	must(configv1.AddToScheme(scheme))
+	must(ipamv1beta1.AddToScheme(scheme))
+	must(cloudscalev1beta1.AddToScheme(scheme))
 	return scheme
 }()
 
@@ -0,0 +1,273 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// errAddressRangeExhausted is returned if all addresses of an AddressRange are in use.
+var errAddressRangeExhausted = errors.New("no free address in range")
+
+// addressRangeAllocator keeps track of the addresses allocated by the actuator.
+// The allocations are recorded on the Machines as well, the in-memory reservations protect concurrent creates against stale caches.
+// The zero value is ready to use.
+type addressRangeAllocator struct {
+	mu sync.Mutex
+	// reserved maps the allocated addresses to the namespace/name of the machine they are allocated to.
+	reserved map[netip.Addr]string
+}
+
+// reserve reserves the address for the machine with the given key.
+// Must be called with mu held.
+func (r *addressRangeAllocator) reserve(addr netip.Addr, key string) {
+	if r.reserved == nil {
+		r.reserved = make(map[netip.Addr]string)
+	}
+	r.reserved[addr] = key
+}
+
+// release releases all addresses reserved for the machine with the given key.
+// Must be called with mu held.
+func (r *addressRangeAllocator) release(key string) {
+	for addr, k := range r.reserved {
+		if k == key {
+			delete(r.reserved, addr)
+		}
+	}
+}
+
+// addressesFromRanges allocates an address from the AddressFromRange of the interfaces and returns the interfaces with the allocated addresses appended to Addresses.
+// Addresses already recorded on the machine are reused. New allocations are recorded on the machine before returning.
+func (a *Actuator) addressesFromRanges(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext, interfaces []csv1beta1.Interface) ([]csv1beta1.Interface, error) {
+	if !hasAddressFromRange(interfaces) {
+		return interfaces, nil
+	}
+	l := log.FromContext(ctx).WithName("Actuator.addressesFromRanges")
+
+	alloc := &a.addressRangeAllocator
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	key := client.ObjectKeyFromObject(machine).String()
+	allocated, err := allocatedAddresses(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	resolved := make([]csv1beta1.Interface, 0, len(interfaces))
+	for idx, in := range interfaces {
+		r := *in.DeepCopy()
+		if in.AddressFromRange == nil {
+			resolved = append(resolved, r)
+			continue
+		}
+		if in.Type != csv1beta1.InterfaceTypePrivate {
+			return nil, machinecontroller.InvalidMachineConfiguration("interface %d: addressFromRange can only be set on private interfaces", idx)
+		}
+		start, end, err := parseAddressRange(*in.AddressFromRange)
+		if err != nil {
+			return nil, machinecontroller.InvalidMachineConfiguration("interface %d: invalid addressFromRange: %s", idx, err.Error())
+		}
+
+		addr, err := netip.ParseAddr(allocated[strconv.Itoa(idx)])
+		if err != nil || !inRange(addr, start, end) {
+			used, err := a.usedAddresses(ctx, machine, mctx, in.NetworkUUID)
+			if err != nil {
+				return nil, fmt.Errorf("interface %d: failed to determine used addresses: %w", idx, err)
+			}
+			addr, err = firstFreeAddress(start, end, used, alloc.reserved)
+			if err != nil {
+				return nil, fmt.Errorf("interface %d: %w %s-%s", idx, err, start, end)
+			}
+			allocated[strconv.Itoa(idx)] = addr.String()
+			changed = true
+			l.Info("Allocated address from range", "machine", machine.Name, "interface", idx, "address", addr)
+		}
+		alloc.reserve(addr, key)
+
+		r.Addresses = append(r.Addresses, csv1beta1.Address{Address: addr.String()})
+		resolved = append(resolved, r)
+	}
+
+	if changed {
+		if err := a.recordAllocatedAddresses(ctx, machine, allocated); err != nil {
+			alloc.release(key)
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// releaseAddressesFromRanges releases the in-memory reservations of the machine.
+// The allocations recorded on the machine are removed with the machine.
+func (a *Actuator) releaseAddressesFromRanges(machine *machinev1beta1.Machine) {
+	a.addressRangeAllocator.mu.Lock()
+	defer a.addressRangeAllocator.mu.Unlock()
+	a.addressRangeAllocator.release(client.ObjectKeyFromObject(machine).String())
+}
+
+// releaseUnusedAddressesFromRanges removes the allocations of addresses not in use from the machine and releases their in-memory reservations.
+// Used to release the addresses of detached interfaces.
+func (a *Actuator) releaseUnusedAddressesFromRanges(ctx context.Context, machine *machinev1beta1.Machine, inUse map[string]struct{}) error {
+	alloc := &a.addressRangeAllocator
+	alloc.mu.Lock()
+	defer alloc.mu.Unlock()
+
+	allocated, err := allocatedAddresses(machine)
+	if err != nil {
+		return err
+	}
+	key := client.ObjectKeyFromObject(machine).String()
+	changed := false
+	for idx, s := range allocated {
+		if _, ok := inUse[s]; ok {
+			continue
+		}
+		delete(allocated, idx)
+		changed = true
+		if addr, err := netip.ParseAddr(s); err == nil && alloc.reserved[addr] == key {
+			delete(alloc.reserved, addr)
+		}
+		log.FromContext(ctx).WithName("Actuator.releaseUnusedAddressesFromRanges").Info("Released address from range", "machine", machine.Name, "interface", idx, "address", s)
+	}
+	if !changed {
+		return nil
+	}
+	return a.recordAllocatedAddresses(ctx, machine, allocated)
+}
+
+// recordAllocatedAddresses immediately patches the AllocatedAddressesAnnotation on the machine,
+// so that the allocations are visible to other creates before the server is created.
+func (a *Actuator) recordAllocatedAddresses(ctx context.Context, machine *machinev1beta1.Machine, allocated map[string]string) error {
+	raw, err := json.Marshal(allocated)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allocated addresses: %w", err)
+	}
+	patched := machine.DeepCopy()
+	metav1.SetMetaDataAnnotation(&patched.ObjectMeta, csv1beta1.AllocatedAddressesAnnotation, string(raw))
+	if err := a.k8sClient.Patch(ctx, patched, client.MergeFrom(machine)); err != nil {
+		return fmt.Errorf("failed to record allocated addresses on machine %q: %w", machine.Name, err)
+	}
+	metav1.SetMetaDataAnnotation(&machine.ObjectMeta, csv1beta1.AllocatedAddressesAnnotation, string(raw))
+	return nil
+}
+
+// usedAddresses returns the addresses allocated to other Machines in the namespace of the machine
+// and the addresses of the cloudscale servers attached to the network.
+// If networkUUID is empty, the addresses of all private interfaces are considered.
+func (a *Actuator) usedAddresses(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext, networkUUID string) (map[netip.Addr]struct{}, error) {
+	used := make(map[netip.Addr]struct{})
+
+	var machines machinev1beta1.MachineList
+	if err := a.k8sClient.List(ctx, &machines, client.InNamespace(machine.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list machines: %w", err)
+	}
+	for _, m := range machines.Items {
+		if m.Name == machine.Name {
+			continue
+		}
+		allocated, err := allocatedAddresses(&m)
+		if err != nil {
+			log.FromContext(ctx).Info("Ignoring invalid allocated addresses", "machine", m.Name, "error", err.Error())
+			continue
+		}
+		for _, s := range allocated {
+			if addr, err := netip.ParseAddr(s); err == nil {
+				used[addr] = struct{}{}
+			}
+		}
+	}
+
+	servers, err := a.serverClientFactory(mctx.token).List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	for _, s := range servers {
+		for _, in := range s.Interfaces {
+			if in.Type == "public" || (networkUUID != "" && in.Network.UUID != networkUUID) {
+				continue
+			}
+			for _, sa := range in.Addresses {
+				if addr, err := netip.ParseAddr(sa.Address); err == nil {
+					used[addr] = struct{}{}
+				}
+			}
+		}
+	}
+	return used, nil
+}
+
+// allocatedAddresses returns the addresses recorded in the AllocatedAddressesAnnotation of the machine.
+// Returns an empty map if the annotation is not set.
+func allocatedAddresses(machine *machinev1beta1.Machine) (map[string]string, error) {
+	allocated := make(map[string]string)
+	raw, ok := machine.Annotations[csv1beta1.AllocatedAddressesAnnotation]
+	if !ok {
+		return allocated, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &allocated); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %q of machine %q: %w", csv1beta1.AllocatedAddressesAnnotation, machine.Name, err)
+	}
+	return allocated, nil
+}
+
+// firstFreeAddress returns the first address between start and end that is neither used nor reserved.
+func firstFreeAddress(start, end netip.Addr, used map[netip.Addr]struct{}, reserved map[netip.Addr]string) (netip.Addr, error) {
+	for addr := start; addr.IsValid() && addr.Compare(end) <= 0; addr = addr.Next() {
+		if _, ok := used[addr]; ok {
+			continue
+		}
+		if _, ok := reserved[addr]; ok {
+			continue
+		}
+		return addr, nil
+	}
+	return netip.Addr{}, errAddressRangeExhausted
+}
+
+// parseAddressRange parses the start and end of the range.
+// Both addresses must be of the same family and start must not be after end.
+func parseAddressRange(r csv1beta1.AddressRange) (netip.Addr, netip.Addr, error) {
+	start, err := netip.ParseAddr(r.Start)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := netip.ParseAddr(r.End)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid end: %w", err)
+	}
+	if start.BitLen() != end.BitLen() {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("start %s and end %s are of different address families", start, end)
+	}
+	if start.Compare(end) > 0 {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("start %s is after end %s", start, end)
+	}
+	return start, end, nil
+}
+
+func inRange(addr, start, end netip.Addr) bool {
+	return addr.IsValid() && addr.BitLen() == start.BitLen() && addr.Compare(start) >= 0 && addr.Compare(end) <= 0
+}
+
+func hasAddressFromRange(interfaces []csv1beta1.Interface) bool {
+	for _, in := range interfaces {
+		if in.AddressFromRange != nil {
+			return true
+		}
+	}
+	return false
+}
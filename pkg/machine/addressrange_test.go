@@ -0,0 +1,174 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine/csmock"
+)
+
+func Test_Actuator_addressesFromRanges(t *testing.T) {
+	const ns = "openshift-machine-api"
+	newMachine := func(name string, allocated string) *machinev1beta1.Machine {
+		m := &machinev1beta1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+			},
+		}
+		if allocated != "" {
+			m.Annotations = map[string]string{csv1beta1.AllocatedAddressesAnnotation: allocated}
+		}
+		return m
+	}
+	interfaces := []csv1beta1.Interface{
+		{Type: csv1beta1.InterfaceTypePublic},
+		{
+			Type:             csv1beta1.InterfaceTypePrivate,
+			NetworkUUID:      "net-uuid",
+			AddressFromRange: &csv1beta1.AddressRange{Start: "10.0.0.10", End: "10.0.0.13"},
+		},
+	}
+	servers := []cloudscale.Server{
+		{Interfaces: []cloudscale.Interface{
+			{Type: "public", Addresses: []cloudscale.Address{{Address: "10.0.0.10"}}},
+			{Type: "private", Network: cloudscale.NetworkStub{UUID: "other-net-uuid"}, Addresses: []cloudscale.Address{{Address: "10.0.0.10"}}},
+			{Type: "private", Network: cloudscale.NetworkStub{UUID: "net-uuid"}, Addresses: []cloudscale.Address{{Address: "10.0.0.11"}}},
+		}},
+	}
+	newActuator := func(t *testing.T, servers []cloudscale.Server, objs ...runtime.Object) (*Actuator, client.Client) {
+		ctrl := gomock.NewController(t)
+		ss := csmock.NewMockServerService(ctrl)
+		ss.EXPECT().List(gomock.Any()).Return(servers, nil).AnyTimes()
+		c := newFakeClient(t, objs...)
+		return &Actuator{
+			k8sClient:           c,
+			serverClientFactory: func(string) cloudscale.ServerService { return ss },
+		}, c
+	}
+	allocate := func(t *testing.T, a *Actuator, m *machinev1beta1.Machine) (string, error) {
+		t.Helper()
+		resolved, err := a.addressesFromRanges(t.Context(), m, &machineContext{}, interfaces)
+		if err != nil {
+			return "", err
+		}
+		require.Len(t, resolved, 2)
+		require.Len(t, resolved[1].Addresses, 1)
+		return resolved[1].Addresses[0].Address, nil
+	}
+
+	t.Run("allocates first free address", func(t *testing.T) {
+		m := newMachine("app-a", "")
+		a, c := newActuator(t, servers, m, newMachine("app-b", `{"1":"10.0.0.10"}`))
+
+		addr, err := allocate(t, a, m)
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.12", addr)
+		assert.Nil(t, interfaces[1].Addresses, "spec interfaces must not be modified")
+
+		var persisted machinev1beta1.Machine
+		require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(m), &persisted))
+		assert.JSONEq(t, `{"1":"10.0.0.12"}`, persisted.Annotations[csv1beta1.AllocatedAddressesAnnotation])
+		assert.Equal(t, persisted.Annotations, m.Annotations)
+	})
+
+	t.Run("reuses recorded address", func(t *testing.T) {
+		m := newMachine("app-a", `{"1":"10.0.0.13"}`)
+		ctrl := gomock.NewController(t)
+		a := &Actuator{
+			k8sClient:           newFakeClient(t, m),
+			serverClientFactory: func(string) cloudscale.ServerService { return csmock.NewMockServerService(ctrl) },
+		}
+
+		addr, err := allocate(t, a, m)
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.13", addr)
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		m := newMachine("app-a", "")
+		a, _ := newActuator(t, servers, m,
+			newMachine("app-b", `{"1":"10.0.0.10"}`),
+			newMachine("app-c", `{"1":"10.0.0.12"}`),
+			newMachine("app-d", `{"1":"10.0.0.13"}`),
+		)
+
+		_, err := allocate(t, a, m)
+		require.ErrorIs(t, err, errAddressRangeExhausted)
+		var merr *machinecontroller.MachineError
+		assert.False(t, errors.As(err, &merr), "exhaustion should not be terminal")
+	})
+
+	t.Run("reuse after delete", func(t *testing.T) {
+		var objs []runtime.Object
+		for i := range 4 {
+			objs = append(objs, newMachine(fmt.Sprintf("app-%d", i), ""))
+		}
+		a, c := newActuator(t, nil, objs...)
+
+		for i, expected := range []string{"10.0.0.10", "10.0.0.11", "10.0.0.12", "10.0.0.13"} {
+			addr, err := allocate(t, a, objs[i].(*machinev1beta1.Machine))
+			require.NoError(t, err)
+			assert.Equal(t, expected, addr)
+		}
+
+		extra := newMachine("app-extra", "")
+		require.NoError(t, c.Create(t.Context(), extra))
+		_, err := allocate(t, a, extra)
+		require.ErrorIs(t, err, errAddressRangeExhausted)
+
+		deleted := objs[1].(*machinev1beta1.Machine)
+		a.releaseAddressesFromRanges(deleted)
+		require.NoError(t, c.Delete(t.Context(), deleted))
+
+		addr, err := allocate(t, a, extra)
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.11", addr)
+	})
+
+	t.Run("concurrent creates", func(t *testing.T) {
+		var objs []runtime.Object
+		for i := range 4 {
+			objs = append(objs, newMachine(fmt.Sprintf("app-%d", i), ""))
+		}
+		a, _ := newActuator(t, nil, objs...)
+
+		addrs := make([]string, len(objs))
+		var wg sync.WaitGroup
+		for i, o := range objs {
+			wg.Go(func() {
+				addr, err := allocate(t, a, o.(*machinev1beta1.Machine))
+				assert.NoError(t, err)
+				addrs[i] = addr
+			})
+		}
+		wg.Wait()
+		assert.ElementsMatch(t, []string{"10.0.0.10", "10.0.0.11", "10.0.0.12", "10.0.0.13"}, addrs)
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		m := newMachine("app-a", "")
+		a, _ := newActuator(t, nil, m)
+
+		_, err := a.addressesFromRanges(t.Context(), m, &machineContext{}, []csv1beta1.Interface{{
+			Type:             csv1beta1.InterfaceTypePrivate,
+			AddressFromRange: &csv1beta1.AddressRange{Start: "10.0.0.10", End: "fd00::1"},
+		}})
+		var merr *machinecontroller.MachineError
+		require.True(t, errors.As(err, &merr), "expected terminal error, got %v", err)
+		assert.ErrorContains(t, err, "different address families")
+	})
+}
@@ -0,0 +1,141 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/cloudscale-ch/cloudscale-go-sdk/v6 (interfaces: NetworkService)
+//
+// Generated by this command:
+//
+//	mockgen -destination=./csmock/network_service.go -package csmock github.com/cloudscale-ch/cloudscale-go-sdk/v6 NetworkService
+//
+
+// Package csmock is a generated GoMock package.
+package csmock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	backoff "github.com/cenkalti/backoff/v5"
+	cloudscale "github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockNetworkService is a mock of NetworkService interface.
+type MockNetworkService struct {
+	ctrl     *gomock.Controller
+	recorder *MockNetworkServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockNetworkServiceMockRecorder is the mock recorder for MockNetworkService.
+type MockNetworkServiceMockRecorder struct {
+	mock *MockNetworkService
+}
+
+// NewMockNetworkService creates a new mock instance.
+func NewMockNetworkService(ctrl *gomock.Controller) *MockNetworkService {
+	mock := &MockNetworkService{ctrl: ctrl}
+	mock.recorder = &MockNetworkServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockNetworkService) EXPECT() *MockNetworkServiceMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockNetworkService) Create(ctx context.Context, createRequest *cloudscale.NetworkCreateRequest) (*cloudscale.Network, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, createRequest)
+	ret0, _ := ret[0].(*cloudscale.Network)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockNetworkServiceMockRecorder) Create(ctx, createRequest any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockNetworkService)(nil).Create), ctx, createRequest)
+}
+
+// Delete mocks base method.
+func (m *MockNetworkService) Delete(ctx context.Context, resourceID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, resourceID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockNetworkServiceMockRecorder) Delete(ctx, resourceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockNetworkService)(nil).Delete), ctx, resourceID)
+}
+
+// Get mocks base method.
+func (m *MockNetworkService) Get(ctx context.Context, resourceID string) (*cloudscale.Network, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, resourceID)
+	ret0, _ := ret[0].(*cloudscale.Network)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockNetworkServiceMockRecorder) Get(ctx, resourceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockNetworkService)(nil).Get), ctx, resourceID)
+}
+
+// List mocks base method.
+func (m *MockNetworkService) List(ctx context.Context, modifiers ...cloudscale.ListRequestModifier) ([]cloudscale.Network, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx}
+	for _, a := range modifiers {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "List", varargs...)
+	ret0, _ := ret[0].([]cloudscale.Network)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockNetworkServiceMockRecorder) List(ctx any, modifiers ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx}, modifiers...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockNetworkService)(nil).List), varargs...)
+}
+
+// Update mocks base method.
+func (m *MockNetworkService) Update(ctx context.Context, resourceID string, updateRequest *cloudscale.NetworkUpdateRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, resourceID, updateRequest)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockNetworkServiceMockRecorder) Update(ctx, resourceID, updateRequest any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockNetworkService)(nil).Update), ctx, resourceID, updateRequest)
+}
+
+// WaitFor mocks base method.
+func (m *MockNetworkService) WaitFor(ctx context.Context, resourceID string, condition func(*cloudscale.Network) (bool, error), opts ...backoff.RetryOption) (*cloudscale.Network, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, resourceID, condition}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "WaitFor", varargs...)
+	ret0, _ := ret[0].(*cloudscale.Network)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WaitFor indicates an expected call of WaitFor.
+func (mr *MockNetworkServiceMockRecorder) WaitFor(ctx, resourceID, condition any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, resourceID, condition}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitFor", reflect.TypeOf((*MockNetworkService)(nil).WaitFor), varargs...)
+}
@@ -0,0 +1,141 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/cloudscale-ch/cloudscale-go-sdk/v6 (interfaces: SubnetService)
+//
+// Generated by this command:
+//
+//	mockgen -destination=./csmock/subnet_service.go -package csmock github.com/cloudscale-ch/cloudscale-go-sdk/v6 SubnetService
+//
+
+// Package csmock is a generated GoMock package.
+package csmock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	backoff "github.com/cenkalti/backoff/v5"
+	cloudscale "github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockSubnetService is a mock of SubnetService interface.
+type MockSubnetService struct {
+	ctrl     *gomock.Controller
+	recorder *MockSubnetServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockSubnetServiceMockRecorder is the mock recorder for MockSubnetService.
+type MockSubnetServiceMockRecorder struct {
+	mock *MockSubnetService
+}
+
+// NewMockSubnetService creates a new mock instance.
+func NewMockSubnetService(ctrl *gomock.Controller) *MockSubnetService {
+	mock := &MockSubnetService{ctrl: ctrl}
+	mock.recorder = &MockSubnetServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSubnetService) EXPECT() *MockSubnetServiceMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockSubnetService) Create(ctx context.Context, createRequest *cloudscale.SubnetCreateRequest) (*cloudscale.Subnet, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, createRequest)
+	ret0, _ := ret[0].(*cloudscale.Subnet)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockSubnetServiceMockRecorder) Create(ctx, createRequest any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSubnetService)(nil).Create), ctx, createRequest)
+}
+
+// Delete mocks base method.
+func (m *MockSubnetService) Delete(ctx context.Context, resourceID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, resourceID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockSubnetServiceMockRecorder) Delete(ctx, resourceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockSubnetService)(nil).Delete), ctx, resourceID)
+}
+
+// Get mocks base method.
+func (m *MockSubnetService) Get(ctx context.Context, resourceID string) (*cloudscale.Subnet, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, resourceID)
+	ret0, _ := ret[0].(*cloudscale.Subnet)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockSubnetServiceMockRecorder) Get(ctx, resourceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockSubnetService)(nil).Get), ctx, resourceID)
+}
+
+// List mocks base method.
+func (m *MockSubnetService) List(ctx context.Context, modifiers ...cloudscale.ListRequestModifier) ([]cloudscale.Subnet, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx}
+	for _, a := range modifiers {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "List", varargs...)
+	ret0, _ := ret[0].([]cloudscale.Subnet)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockSubnetServiceMockRecorder) List(ctx any, modifiers ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx}, modifiers...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockSubnetService)(nil).List), varargs...)
+}
+
+// Update mocks base method.
+func (m *MockSubnetService) Update(ctx context.Context, resourceID string, updateRequest *cloudscale.SubnetUpdateRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, resourceID, updateRequest)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockSubnetServiceMockRecorder) Update(ctx, resourceID, updateRequest any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockSubnetService)(nil).Update), ctx, resourceID, updateRequest)
+}
+
+// WaitFor mocks base method.
+func (m *MockSubnetService) WaitFor(ctx context.Context, resourceID string, condition func(*cloudscale.Subnet) (bool, error), opts ...backoff.RetryOption) (*cloudscale.Subnet, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, resourceID, condition}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "WaitFor", varargs...)
+	ret0, _ := ret[0].(*cloudscale.Subnet)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WaitFor indicates an expected call of WaitFor.
+func (mr *MockSubnetServiceMockRecorder) WaitFor(ctx, resourceID, condition any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, resourceID, condition}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitFor", reflect.TypeOf((*MockSubnetService)(nil).WaitFor), varargs...)
+}
@@ -0,0 +1,102 @@
+package machine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// Condition reasons for the UserDataUpToDate condition.
+const (
+	conditionReasonUserDataUpToDate    = "UpToDate"
+	conditionReasonUserDataDrifted     = "Drifted"
+	conditionReasonUserDataUnknown     = "NoUserDataHash"
+	conditionReasonUserDataRenderError = "RenderFailed"
+)
+
+// userDataHash returns the hex encoded SHA-256 hash of the user data.
+func userDataHash(userData string) string {
+	h := sha256.Sum256([]byte(userData))
+	return hex.EncodeToString(h[:])
+}
+
+// recordUserData stores the hash of the user data the server was created with in the provider status.
+func recordUserData(machine *machinev1beta1.Machine, mctx *machineContext, userData string) error {
+	if mctx.spec.UserDataSecret == nil {
+		return nil
+	}
+	return updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+		status.UserDataHash = userDataHash(userData)
+		status.UserDataSecretResourceVersion = mctx.userDataSecretResourceVersion
+		status.UserDataSourcesVersion = mctx.userDataSourcesVersion
+	})
+}
+
+// checkUserDataDrift renders the user data of the machine again and sets the UserDataUpToDate condition,
+// depending on whether it matches the user data the server was created with.
+// Failing to render the user data is reported in the condition and does not fail the update.
+// The user data is only rendered again if the objects it is rendered from or the machine changed since the last check,
+// otherwise the last condition is kept.
+func (a *Actuator) checkUserDataDrift(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext, s cloudscale.Server) error {
+	if mctx.spec.UserDataSecret == nil {
+		return nil
+	}
+
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get provider status from machine: %w", err)
+	}
+
+	cond := metav1.Condition{
+		Type:               csv1beta1.UserDataUpToDateCondition,
+		Status:             metav1.ConditionUnknown,
+		Reason:             conditionReasonUserDataUnknown,
+		Message:            "The user data the server was created with is unknown",
+		ObservedGeneration: machine.Generation,
+	}
+	if status.UserDataHash != "" {
+		prev := meta.FindStatusCondition(status.Conditions, csv1beta1.UserDataUpToDateCondition)
+		if prev != nil && prev.ObservedGeneration == machine.Generation && status.UserDataSourcesVersion != "" {
+			version, err := a.currentUserDataSourcesVersion(ctx, mctx)
+			if err == nil && version == status.UserDataSourcesVersion {
+				return nil
+			}
+		}
+
+		mctx.serverGroups = make([]string, 0, len(s.ServerGroups))
+		for _, sg := range s.ServerGroups {
+			mctx.serverGroups = append(mctx.serverGroups, sg.UUID)
+		}
+
+		ud, err := a.loadAndRenderUserDataSecret(ctx, mctx)
+		switch {
+		case err != nil:
+			cond.Reason = conditionReasonUserDataRenderError
+			cond.Message = fmt.Sprintf("Failed to render user data: %s", mctx.redactError(err))
+		case userDataHash(ud) == status.UserDataHash:
+			cond.Status = metav1.ConditionTrue
+			cond.Reason = conditionReasonUserDataUpToDate
+			cond.Message = "The user data matches the user data the server was created with"
+		default:
+			cond.Status = metav1.ConditionFalse
+			cond.Reason = conditionReasonUserDataDrifted
+			cond.Message = fmt.Sprintf("The user data rendered from secret %q (resource version %s) differs from the user data the server was created with (resource version %s)",
+				mctx.spec.UserDataSecret.Name, mctx.userDataSecretResourceVersion, status.UserDataSecretResourceVersion)
+		}
+		if err := updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+			status.UserDataSourcesVersion = mctx.userDataSourcesVersion
+		}); err != nil {
+			return err
+		}
+	}
+
+	return setProviderStatusCondition(machine, cond)
+}
@@ -0,0 +1,253 @@
+package machine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+func Test_Actuator_checkUserDataDrift(t *testing.T) {
+	t.Parallel()
+
+	const createdWith = `{"ignition":{"version":"3.1.0"},"sg":["sg-uuid"]}`
+
+	tcs := []struct {
+		name           string
+		userData       string
+		noSecret       bool
+		recordedHash   string
+		expectedStatus metav1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:           "up to date",
+			userData:       `{ ignition: { version: '3.1.0' }, sg: std.extVar('context').serverGroups }`,
+			recordedHash:   userDataHash(createdWith),
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: conditionReasonUserDataUpToDate,
+		},
+		{
+			name:           "drifted",
+			userData:       `{ ignition: { version: '3.2.0' }, sg: std.extVar('context').serverGroups }`,
+			recordedHash:   userDataHash(createdWith),
+			expectedStatus: metav1.ConditionFalse,
+			expectedReason: conditionReasonUserDataDrifted,
+		},
+		{
+			name:           "no recorded hash",
+			userData:       `{}`,
+			expectedStatus: metav1.ConditionUnknown,
+			expectedReason: conditionReasonUserDataUnknown,
+		},
+		{
+			name:           "render error",
+			userData:       `error 'broken'`,
+			recordedHash:   userDataHash(createdWith),
+			expectedStatus: metav1.ConditionUnknown,
+			expectedReason: conditionReasonUserDataRenderError,
+		},
+		{
+			name:         "no user data secret",
+			noSecret:     true,
+			recordedHash: userDataHash(createdWith),
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			userDataSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+				Data: map[string][]byte{
+					"userData": []byte(tc.userData),
+				},
+			}
+			actuator := newActuator(newFakeClient(t, userDataSecret), nil, nil, nil)
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+			}
+			require.NoError(t, updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+				status.UserDataHash = tc.recordedHash
+				status.UserDataSecretResourceVersion = "1"
+			}))
+			mctx := &machineContext{machine: machine.DeepCopy()}
+			if !tc.noSecret {
+				mctx.spec.UserDataSecret = &corev1.LocalObjectReference{Name: userDataSecret.Name}
+			}
+
+			require.NoError(t, actuator.checkUserDataDrift(ctx, machine, mctx, cloudscale.Server{
+				ServerGroups: []cloudscale.ServerGroupStub{{UUID: "sg-uuid"}},
+			}))
+
+			status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+			require.NoError(t, err)
+			cond := meta.FindStatusCondition(status.Conditions, csv1beta1.UserDataUpToDateCondition)
+			if tc.expectedStatus == "" {
+				assert.Nil(t, cond)
+				return
+			}
+			require.NotNil(t, cond)
+			assert.Equal(t, tc.expectedStatus, cond.Status)
+			assert.Equal(t, tc.expectedReason, cond.Reason)
+		})
+	}
+}
+
+func Test_recordUserData(t *testing.T) {
+	t.Parallel()
+
+	machine := &machinev1beta1.Machine{}
+	mctx := &machineContext{
+		spec: csv1beta1.CloudscaleMachineProviderSpec{
+			UserDataSecret: &corev1.LocalObjectReference{Name: "app-user-data"},
+		},
+		userDataSecretResourceVersion: "42",
+	}
+	require.NoError(t, recordUserData(machine, mctx, `{"ignition":{}}`))
+
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	require.NoError(t, err)
+	assert.Equal(t, "9a3863e04fb520e69e33ce13446e67b122986a35b8601f6f828fa75f606ed73d", status.UserDataHash)
+	assert.Equal(t, "42", status.UserDataSecretResourceVersion)
+}
+
+func Test_Actuator_checkUserDataDrift_UnchangedSources(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	userDataSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+		Data: map[string][]byte{
+			"userData": []byte(`{ ignition: { version: '3.1.0' } }`),
+		},
+	}
+	c := newFakeClient(t, userDataSecret)
+	actuator := newActuator(c, nil, nil, nil)
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default", Generation: 1},
+	}
+	mctx := &machineContext{
+		machine: machine.DeepCopy(),
+		spec: csv1beta1.CloudscaleMachineProviderSpec{
+			UserDataSecret: &corev1.LocalObjectReference{Name: userDataSecret.Name},
+		},
+	}
+	version, err := actuator.currentUserDataSourcesVersion(ctx, mctx)
+	require.NoError(t, err)
+	require.NoError(t, updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+		status.UserDataHash = userDataHash(`{"ignition":{"version":"3.1.0"}}`)
+		status.UserDataSourcesVersion = version
+	}))
+	require.NoError(t, setProviderStatusCondition(machine, metav1.Condition{
+		Type:               csv1beta1.UserDataUpToDateCondition,
+		Status:             metav1.ConditionFalse,
+		Reason:             conditionReasonUserDataDrifted,
+		ObservedGeneration: machine.Generation,
+	}))
+
+	reason := func() string {
+		status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+		require.NoError(t, err)
+		cond := meta.FindStatusCondition(status.Conditions, csv1beta1.UserDataUpToDateCondition)
+		require.NotNil(t, cond)
+		return cond.Reason
+	}
+
+	require.NoError(t, actuator.checkUserDataDrift(ctx, machine, mctx, cloudscale.Server{}))
+	assert.Equal(t, conditionReasonUserDataDrifted, reason(), "the user data should not be rendered again if the sources did not change")
+
+	userDataSecret.Annotations = map[string]string{"changed": "true"}
+	require.NoError(t, c.Update(ctx, userDataSecret))
+	require.NoError(t, actuator.checkUserDataDrift(ctx, machine, mctx, cloudscale.Server{}))
+	assert.Equal(t, conditionReasonUserDataUpToDate, reason(), "the user data should be rendered again if the user data secret changed")
+
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	require.NoError(t, err)
+	assert.NotEqual(t, version, status.UserDataSourcesVersion)
+	assert.NotEmpty(t, status.UserDataSourcesVersion)
+}
+
+func Test_Actuator_checkUserDataDrift_MachineUpdatedAfterCreate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	userDataSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+		Data: map[string][]byte{
+			"userData": []byte(`local machine = std.extVar('context').machine;
+{
+  labels: machine.metadata.labels,
+  annotations: std.get(machine.metadata, 'annotations', {}),
+  providerID: std.get(machine.spec, 'providerID'),
+  status: std.get(machine, 'status', {}),
+}`),
+		},
+	}
+	actuator := newActuator(newFakeClient(t, userDataSecret), nil, nil, nil)
+	spec := csv1beta1.CloudscaleMachineProviderSpec{
+		UserDataSecret: &corev1.LocalObjectReference{Name: userDataSecret.Name},
+	}
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-test",
+			Namespace:   "default",
+			Generation:  1,
+			Labels:      map[string]string{"node-role.kubernetes.io/app": ""},
+			Annotations: map[string]string{"example.com/note": "created"},
+		},
+	}
+	createMctx := &machineContext{machine: machine.DeepCopy(), spec: spec}
+	createdWith, err := actuator.loadAndRenderUserDataSecret(ctx, createMctx)
+	require.NoError(t, err)
+	require.NoError(t, recordUserData(machine, createMctx, createdWith))
+
+	s := cloudscale.Server{
+		UUID:          "server-uuid",
+		Status:        "running",
+		Flavor:        cloudscale.Flavor{Slug: "flex-4-2"},
+		ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "rma1"}},
+	}
+	require.NoError(t, updateMachineFromCloudscaleServer(machine, nil, s))
+	machine.Generation = 2
+	machine.ResourceVersion = "42"
+	machine.Annotations[csv1beta1.AllocatedAddressesAnnotation] = `{"0":"10.0.0.10"}`
+	machine.Annotations[machinecontroller.MachineInstanceStateAnnotationName] = "running"
+
+	mctx := &machineContext{machine: machine.DeepCopy(), spec: spec}
+	require.NoError(t, actuator.checkUserDataDrift(ctx, machine, mctx, s))
+
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	require.NoError(t, err)
+	cond := meta.FindStatusCondition(status.Conditions, csv1beta1.UserDataUpToDateCondition)
+	require.NotNil(t, cond)
+	assert.Equal(t, conditionReasonUserDataUpToDate, cond.Reason, "the fields set after the server was created should not cause drift")
+
+	machine.Labels["node-role.kubernetes.io/infra"] = ""
+	mctx = &machineContext{machine: machine.DeepCopy(), spec: spec}
+	machine.Generation = 3
+	require.NoError(t, actuator.checkUserDataDrift(ctx, machine, mctx, s))
+
+	status, err = csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	require.NoError(t, err)
+	cond = meta.FindStatusCondition(status.Conditions, csv1beta1.UserDataUpToDateCondition)
+	require.NotNil(t, cond)
+	assert.Equal(t, conditionReasonUserDataDrifted, cond.Reason, "labels set by users should be rendered")
+}
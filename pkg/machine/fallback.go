@@ -0,0 +1,93 @@
+package machine
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// capacityErrorMessage matches the messages of cloudscale API errors caused by missing capacity of a flavor in a zone.
+var capacityErrorMessage = regexp.MustCompile(`(?i)capacity|temporarily unavailable|currently not available|out of stock`)
+
+// isCapacityError returns true if the error is a cloudscale API error caused by missing capacity.
+// Only capacity errors are retried with the FlavorFallbacks and ZoneFallbacks of a machine.
+// The status code alone is not enough, the API also returns 503 Service Unavailable during maintenance.
+func isCapacityError(err error) bool {
+	var errResp *cloudscale.ErrorResponse
+	if !errors.As(err, &errResp) {
+		return false
+	}
+	switch errResp.StatusCode {
+	case http.StatusBadRequest, http.StatusConflict, http.StatusServiceUnavailable:
+	default:
+		return false
+	}
+	for _, msg := range errResp.Message {
+		if capacityErrorMessage.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateZoneFallbacks returns a terminal error if ZoneFallbacks are combined with settings that are bound to a single zone.
+// Server groups, networks and subnets selected by UUID and allocated addresses only exist in one zone.
+func validateZoneFallbacks(spec csv1beta1.CloudscaleMachineProviderSpec) error {
+	if len(spec.ZoneFallbacks) == 0 {
+		return nil
+	}
+
+	var invalid []string
+	if len(spec.Zones) > 0 {
+		invalid = append(invalid, "zones")
+	}
+	if len(spec.ServerGroups) > 0 {
+		invalid = append(invalid, "serverGroups")
+	}
+	for _, iface := range spec.Interfaces {
+		if iface.NetworkUUID != "" {
+			invalid = append(invalid, "interfaces[].networkUUID")
+		}
+		if slices.ContainsFunc(iface.Addresses, func(a csv1beta1.Address) bool { return a.SubnetUUID != "" }) {
+			invalid = append(invalid, "interfaces[].addresses[].subnetUUID")
+		}
+		if len(iface.AddressesFromPools) > 0 {
+			invalid = append(invalid, "interfaces[].addressesFromPools")
+		}
+		if iface.AddressFromRange != nil {
+			invalid = append(invalid, "interfaces[].addressFromRange")
+		}
+	}
+	if len(invalid) > 0 {
+		slices.Sort(invalid)
+		return machinecontroller.InvalidMachineConfiguration("zoneFallbacks can't be combined with %s", strings.Join(slices.Compact(invalid), ", "))
+	}
+	return nil
+}
+
+// serverZones returns the zone of the spec followed by its ZoneFallbacks, in the order they are tried.
+func serverZones(spec csv1beta1.CloudscaleMachineProviderSpec) []string {
+	return append([]string{spec.Zone}, spec.ZoneFallbacks...)
+}
+
+// serverFlavors returns the flavor of the spec followed by its FlavorFallbacks, in the order they are tried.
+func serverFlavors(spec csv1beta1.CloudscaleMachineProviderSpec) []string {
+	return append([]string{spec.Flavor}, spec.FlavorFallbacks...)
+}
+
+// applyFallbackZone sets the zone of a spec with ZoneFallbacks to the given zone.
+// Returns false if the zone is not in ZoneFallbacks.
+func applyFallbackZone(spec *csv1beta1.CloudscaleMachineProviderSpec, zone string) bool {
+	if !slices.Contains(spec.ZoneFallbacks, zone) {
+		return false
+	}
+	spec.Zone = zone
+	return true
+}
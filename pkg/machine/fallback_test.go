@@ -0,0 +1,382 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine/csmock"
+)
+
+func Test_isCapacityError(t *testing.T) {
+	tcs := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name: "service unavailable",
+			err:  &cloudscale.ErrorResponse{StatusCode: http.StatusServiceUnavailable},
+		},
+		{
+			name: "service unavailable for capacity reasons",
+			err: &cloudscale.ErrorResponse{
+				StatusCode: http.StatusServiceUnavailable,
+				Message:    map[string]string{"detail": "Flavor temporarily unavailable."},
+			},
+			expected: true,
+		},
+		{
+			name: "flavor not available",
+			err: fmt.Errorf("wrapped: %w", &cloudscale.ErrorResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    map[string]string{"flavor": "The flavor plus-32-8 is currently not available in zone rma1."},
+			}),
+			expected: true,
+		},
+		{
+			name: "insufficient capacity",
+			err: &cloudscale.ErrorResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    map[string]string{"detail": "Insufficient capacity."},
+			},
+			expected: true,
+		},
+		{
+			name: "invalid flavor",
+			err: &cloudscale.ErrorResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    map[string]string{"flavor": "Invalid flavor."},
+			},
+		},
+		{
+			name: "unauthorized",
+			err: &cloudscale.ErrorResponse{
+				StatusCode: http.StatusUnauthorized,
+				Message:    map[string]string{"detail": "capacity"},
+			},
+		},
+		{
+			name: "other error",
+			err:  errors.New("capacity"),
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isCapacityError(tc.err))
+		})
+	}
+}
+
+// Test_isCapacityError_APIResponses pins the error responses of the cloudscale API treated as capacity errors.
+// The responses are decoded by the cloudscale SDK, like the responses of the API.
+func Test_isCapacityError_APIResponses(t *testing.T) {
+	tcs := []struct {
+		name       string
+		statusCode int
+		body       string
+		expected   bool
+	}{
+		{
+			name:       "flavor not available in zone",
+			statusCode: http.StatusBadRequest,
+			body:       `{"detail": "The flavor plus-32-8 is currently not available in zone rma1."}`,
+			expected:   true,
+		},
+		{
+			name:       "insufficient capacity",
+			statusCode: http.StatusBadRequest,
+			body:       `{"detail": "Insufficient capacity to create a server with flavor plus-32-8 in zone rma1."}`,
+			expected:   true,
+		},
+		{
+			name:       "conflict for capacity reasons",
+			statusCode: http.StatusConflict,
+			body:       `{"detail": "The requested resources are temporarily unavailable."}`,
+			expected:   true,
+		},
+		{
+			name:       "service unavailable for capacity reasons",
+			statusCode: http.StatusServiceUnavailable,
+			body:       `{"detail": "No capacity left for flavor plus-32-8 in zone rma1."}`,
+			expected:   true,
+		},
+		{
+			name:       "maintenance",
+			statusCode: http.StatusServiceUnavailable,
+			body:       `{"detail": "The API is currently undergoing maintenance. Please try again later."}`,
+		},
+		{
+			name:       "service unavailable without body",
+			statusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "invalid flavor",
+			statusCode: http.StatusBadRequest,
+			body:       `{"flavor": "Invalid flavor plus-32-9."}`,
+		},
+		{
+			name:       "volume size",
+			statusCode: http.StatusBadRequest,
+			body:       `{"volume_size_gb": "Ensure this value is greater than or equal to 10."}`,
+		},
+		{
+			name:       "rate limited",
+			statusCode: http.StatusTooManyRequests,
+			body:       `{"detail": "Request was throttled. Expected available in 10 seconds."}`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := cloudscale.CheckResponse(&http.Response{
+				StatusCode: tc.statusCode,
+				Body:       io.NopCloser(strings.NewReader(tc.body)),
+			})
+			require.Error(t, err)
+			assert.Equal(t, tc.expected, isCapacityError(fmt.Errorf("failed to create machine: %w", err)))
+		})
+	}
+}
+
+func Test_validateZoneFallbacks(t *testing.T) {
+	assert.NoError(t, validateZoneFallbacks(csv1beta1.CloudscaleMachineProviderSpec{
+		ServerGroups: []string{"uuid"},
+	}), "settings bound to a zone are allowed without zone fallbacks")
+	assert.NoError(t, validateZoneFallbacks(csv1beta1.CloudscaleMachineProviderSpec{
+		ZoneFallbacks:    []string{"lpg1"},
+		ServerGroupNames: []string{"web"},
+		Interfaces: []csv1beta1.Interface{
+			{Type: csv1beta1.InterfaceTypePublic},
+			{Type: csv1beta1.InterfaceTypePrivate, NetworkName: "private", Addresses: []csv1beta1.Address{{SubnetCIDR: "10.0.0.0/24"}}},
+		},
+	}))
+
+	err := validateZoneFallbacks(csv1beta1.CloudscaleMachineProviderSpec{
+		ZoneFallbacks: []string{"lpg1"},
+		ServerGroups:  []string{"uuid"},
+		Interfaces: []csv1beta1.Interface{
+			{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-a"},
+			{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-b", AddressFromRange: &csv1beta1.AddressRange{Start: "10.0.0.10", End: "10.0.0.20"}},
+		},
+	})
+	require.EqualError(t, err, "zoneFallbacks can't be combined with interfaces[].addressFromRange, interfaces[].networkUUID, serverGroups")
+	var merr *machinecontroller.MachineError
+	assert.True(t, errors.As(err, &merr), "invalid fallbacks should be a terminal error")
+}
+
+func Test_Actuator_Create_Fallbacks(t *testing.T) {
+	const clusterID = "cluster-id"
+
+	capacityErr := &cloudscale.ErrorResponse{
+		StatusCode: http.StatusBadRequest,
+		Message:    map[string]string{"flavor": "The flavor is currently not available in this zone."},
+	}
+	expectCreate := func(ss *csmock.MockServerService, zone, flavor string, err error) *gomock.Call {
+		return ss.EXPECT().Create(gomock.Any(), gomock.Cond(func(req *cloudscale.ServerRequest) bool {
+			return req.Zone == zone && req.Flavor == flavor
+		})).DoAndReturn(func(ctx context.Context, req *cloudscale.ServerRequest) (*cloudscale.Server, error) {
+			if err != nil {
+				return nil, err
+			}
+			return cloudscaleServerFromServerRequest(func(*cloudscale.Server) {})(ctx, req)
+		})
+	}
+
+	tcs := []struct {
+		name           string
+		apiMock        func(*csmock.MockServerService)
+		expectedZone   string
+		expectedFlavor string
+		expectedErr    string
+		expectedEvents []string
+	}{
+		{
+			name: "no fallback needed",
+			apiMock: func(ss *csmock.MockServerService) {
+				expectCreate(ss, "rma1", "plus-16-4", nil)
+			},
+			expectedZone:   "rma1",
+			expectedFlavor: "plus-16-4",
+			expectedEvents: []string{
+				`Normal Created Created server "app-test" with UUID "UUID"`,
+			},
+		},
+		{
+			name: "flavor fallback",
+			apiMock: func(ss *csmock.MockServerService) {
+				gomock.InOrder(
+					expectCreate(ss, "rma1", "plus-16-4", capacityErr),
+					expectCreate(ss, "rma1", "flex-16-4", nil),
+				)
+			},
+			expectedZone:   "rma1",
+			expectedFlavor: "flex-16-4",
+			expectedEvents: []string{
+				`Warning CapacityFallback Failed to create server with flavor "plus-16-4" in zone "rma1" for capacity reasons, falling back to flavor "flex-16-4"`,
+				`Normal Created Created server "app-test" with UUID "UUID"`,
+			},
+		},
+		{
+			name: "zone fallback",
+			apiMock: func(ss *csmock.MockServerService) {
+				gomock.InOrder(
+					expectCreate(ss, "rma1", "plus-16-4", capacityErr),
+					expectCreate(ss, "rma1", "flex-16-4", &cloudscale.ErrorResponse{
+						StatusCode: http.StatusServiceUnavailable,
+						Message:    map[string]string{"detail": "Insufficient capacity."},
+					}),
+					expectCreate(ss, "lpg1", "plus-16-4", nil),
+				)
+			},
+			expectedZone:   "lpg1",
+			expectedFlavor: "plus-16-4",
+			expectedEvents: []string{
+				`Warning CapacityFallback Failed to create server with flavor "plus-16-4" in zone "rma1" for capacity reasons, falling back to flavor "flex-16-4"`,
+				`Warning CapacityFallback Failed to create server in zone "rma1" for capacity reasons, falling back to zone "lpg1"`,
+				`Normal Created Created server "app-test" with UUID "UUID"`,
+			},
+		},
+		{
+			name: "other errors are not retried",
+			apiMock: func(ss *csmock.MockServerService) {
+				expectCreate(ss, "rma1", "plus-16-4", &cloudscale.ErrorResponse{
+					StatusCode: http.StatusBadRequest,
+					Message:    map[string]string{"image": "Invalid image."},
+				})
+			},
+			expectedErr: "Invalid image.",
+		},
+		{
+			name: "all fallbacks exhausted",
+			apiMock: func(ss *csmock.MockServerService) {
+				ss.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil, capacityErr).Times(4)
+			},
+			expectedErr: "currently not available",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app-test",
+					Labels: map[string]string{
+						machineClusterIDLabelName: clusterID,
+					},
+				},
+			}
+			setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{
+				Zone:            "rma1",
+				ZoneFallbacks:   []string{"lpg1"},
+				Flavor:          "plus-16-4",
+				FlavorFallbacks: []string{"flex-16-4"},
+			})
+
+			c := newFakeClient(t, machine)
+			ss := csmock.NewMockServerService(ctrl)
+			actuator := newActuator(c, ss, nil, nil)
+
+			tc.apiMock(ss)
+
+			err := actuator.Create(t.Context(), machine)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedEvents, drainEvents(actuator.eventRecorder.(*record.FakeRecorder)))
+
+			var updated machinev1beta1.Machine
+			require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(machine), &updated))
+			assert.Equal(t, tc.expectedZone, updated.Labels[machinecontroller.MachineAZLabelName])
+			assert.Equal(t, tc.expectedFlavor, updated.Labels[machinecontroller.MachineInstanceTypeLabelName])
+			status, err := csv1beta1.ProviderStatusFromRawExtension(updated.Status.ProviderStatus)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedZone, status.Zone)
+			assert.Equal(t, tc.expectedFlavor, status.Flavor)
+		})
+	}
+}
+
+func Test_Actuator_Create_ZoneFallback_DeletesCreatedServerGroups(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app-test",
+			Labels: map[string]string{
+				machineClusterIDLabelName: "cluster-id",
+			},
+		},
+	}
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{
+		Zone:             "rma1",
+		ZoneFallbacks:    []string{"lpg1"},
+		Flavor:           "plus-16-4",
+		ServerGroupNames: []string{"db", "web"},
+		AntiAffinityKey:  "app",
+	})
+
+	c := newFakeClient(t, machine)
+	ss := csmock.NewMockServerService(ctrl)
+	sgs := csmock.NewMockServerGroupService(ctrl)
+	actuator := newActuator(c, ss, sgs, nil)
+
+	inZone := func(uuid, name, zone string) cloudscale.ServerGroup {
+		return cloudscale.ServerGroup{UUID: uuid, Name: name, ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: zone}}}
+	}
+	expectCreateGroup := func(zone, name, uuid string) *gomock.Call {
+		return sgs.EXPECT().Create(gomock.Any(), gomock.Cond(func(req *cloudscale.ServerGroupRequest) bool {
+			return req.Zone == zone && req.Name == name
+		})).Return(&cloudscale.ServerGroup{UUID: uuid}, nil)
+	}
+	sgs.EXPECT().List(gomock.Any()).Return([]cloudscale.ServerGroup{
+		inZone("db-rma1", "db", "rma1"),
+		inZone("db-lpg1", "db", "lpg1"),
+	}, nil).Times(2)
+	sgs.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, nil).Times(2)
+	gomock.InOrder(
+		expectCreateGroup("rma1", "web", "web-rma1"),
+		expectCreateGroup("rma1", "app", "app-rma1"),
+		ss.EXPECT().Create(gomock.Any(), gomock.Cond(func(req *cloudscale.ServerRequest) bool { return req.Zone == "rma1" })).
+			Return(nil, &cloudscale.ErrorResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    map[string]string{"detail": "Insufficient capacity."},
+			}),
+		sgs.EXPECT().Delete(gomock.Any(), "web-rma1").Return(nil),
+		sgs.EXPECT().Delete(gomock.Any(), "app-rma1").Return(errors.New("server group is in use")),
+		expectCreateGroup("lpg1", "web", "web-lpg1"),
+		expectCreateGroup("lpg1", "app", "app-lpg1"),
+		ss.EXPECT().Create(gomock.Any(), gomock.Cond(func(req *cloudscale.ServerRequest) bool {
+			return req.Zone == "lpg1" && assert.ObjectsAreEqual([]string{"db-lpg1", "web-lpg1", "app-lpg1"}, req.ServerGroups)
+		})).DoAndReturn(cloudscaleServerFromServerRequest(func(*cloudscale.Server) {})),
+	)
+
+	require.NoError(t, actuator.Create(t.Context(), machine))
+}
@@ -0,0 +1,326 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// resolveInterfaces returns the interfaces of the provider spec with the network and subnet selectors resolved to UUIDs.
+// The cloudscale API is only queried if a selector is used.
+// Returns a terminal error if a selector matches no or more than one network or subnet.
+func (a *Actuator) resolveInterfaces(ctx context.Context, mctx *machineContext) ([]csv1beta1.Interface, error) {
+	if mctx.spec.Interfaces == nil {
+		return nil, nil
+	}
+
+	resolved := make([]csv1beta1.Interface, 0, len(mctx.spec.Interfaces))
+	for idx, in := range mctx.spec.Interfaces {
+		r := *in.DeepCopy()
+
+		if r.NetworkName != "" || len(r.NetworkTags) > 0 {
+			if r.NetworkUUID != "" {
+				return nil, machinecontroller.InvalidMachineConfiguration("interface %d: networkUUID is mutually exclusive with networkName and networkTags", idx)
+			}
+			uuid, err := a.resolveNetwork(ctx, mctx, r.NetworkName, r.NetworkTags)
+			if err != nil {
+				return nil, fmt.Errorf("interface %d: %w", idx, err)
+			}
+			r.NetworkUUID = uuid
+			r.NetworkName = ""
+			r.NetworkTags = nil
+		}
+
+		for ai, addr := range r.Addresses {
+			if addr.SubnetCIDR == "" && len(addr.SubnetTags) == 0 {
+				continue
+			}
+			if addr.SubnetUUID != "" {
+				return nil, machinecontroller.InvalidMachineConfiguration("interface %d, address %d: subnetUUID is mutually exclusive with subnetCIDR and subnetTags", idx, ai)
+			}
+			uuid, err := a.resolveSubnet(ctx, mctx, r.NetworkUUID, addr.SubnetCIDR, addr.SubnetTags)
+			if err != nil {
+				return nil, fmt.Errorf("interface %d, address %d: %w", idx, ai, err)
+			}
+			r.Addresses[ai].SubnetUUID = uuid
+			r.Addresses[ai].SubnetCIDR = ""
+			r.Addresses[ai].SubnetTags = nil
+		}
+
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// resolveNetwork returns the UUID of the only network in the zone of the machine matching the name and tags.
+func (a *Actuator) resolveNetwork(ctx context.Context, mctx *machineContext, name string, tags map[string]string) (string, error) {
+	var mods []cloudscale.ListRequestModifier
+	if len(tags) > 0 {
+		mods = append(mods, cloudscale.WithTagFilter(tags))
+	}
+	networks, err := a.networkClientFactory(mctx.token).List(ctx, mods...)
+	if err != nil {
+		return "", fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	var matches []string
+	for _, n := range networks {
+		if n.Zone.Slug != mctx.spec.Zone || (name != "" && n.Name != name) {
+			continue
+		}
+		matches = append(matches, n.UUID)
+	}
+	return singleMatch("network", fmt.Sprintf("in zone %q with name %q and tags %v", mctx.spec.Zone, name, tags), matches)
+}
+
+// resolveSubnet returns the UUID of the only subnet matching the CIDR and tags.
+// If networkUUID is not empty, only subnets of that network are considered.
+func (a *Actuator) resolveSubnet(ctx context.Context, mctx *machineContext, networkUUID, cidr string, tags map[string]string) (string, error) {
+	var mods []cloudscale.ListRequestModifier
+	if len(tags) > 0 {
+		mods = append(mods, cloudscale.WithTagFilter(tags))
+	}
+	subnets, err := a.subnetClientFactory(mctx.token).List(ctx, mods...)
+	if err != nil {
+		return "", fmt.Errorf("failed to list subnets: %w", err)
+	}
+
+	var matches []string
+	for _, s := range subnets {
+		if (networkUUID != "" && s.Network.UUID != networkUUID) || (cidr != "" && s.CIDR != cidr) {
+			continue
+		}
+		matches = append(matches, s.UUID)
+	}
+	return singleMatch("subnet", fmt.Sprintf("in network %q with CIDR %q and tags %v", networkUUID, cidr, tags), matches)
+}
+
+// singleMatch returns the only UUID in matches or a terminal error if there is no or more than one match.
+func singleMatch(kind, selector string, matches []string) (string, error) {
+	switch len(matches) {
+	case 0:
+		return "", machinecontroller.InvalidMachineConfiguration("no %s found %s", kind, selector)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", machinecontroller.InvalidMachineConfiguration("%d %ss found %s, expected exactly one: %s", len(matches), kind, selector, strings.Join(matches, ", "))
+	}
+}
+
+// interfaceStatuses returns the provider status of the resolved interfaces.
+func interfaceStatuses(interfaces []csv1beta1.Interface) []csv1beta1.InterfaceStatus {
+	if interfaces == nil {
+		return nil
+	}
+	statuses := make([]csv1beta1.InterfaceStatus, 0, len(interfaces))
+	for _, in := range interfaces {
+		st := csv1beta1.InterfaceStatus{
+			Type:        in.Type,
+			NetworkUUID: in.NetworkUUID,
+		}
+		for _, addr := range in.Addresses {
+			st.SubnetUUIDs = append(st.SubnetUUIDs, addr.SubnetUUID)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// interfacesSpecHash returns the hex encoded SHA-256 hash of the interfaces of a provider spec.
+func interfacesSpecHash(interfaces []csv1beta1.Interface) (string, error) {
+	raw, err := json.Marshal(interfaces)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal interfaces: %w", err)
+	}
+	return userDataHash(string(raw)), nil
+}
+
+// recordInterfaces records the resolved interfaces and the hash of the interfaces of the provider spec they were resolved from in the provider status.
+func recordInterfaces(machine *machinev1beta1.Machine, specInterfaces, resolved []csv1beta1.Interface) error {
+	h, err := interfacesSpecHash(specInterfaces)
+	if err != nil {
+		return err
+	}
+	return updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+		status.Interfaces = interfaceStatuses(resolved)
+		status.InterfacesSpecHash = h
+	})
+}
+
+// reconcileServerInterfaces attaches and detaches networks so that the interfaces of the server match the provider spec.
+// Interfaces of networks already attached keep their addresses. Returns the updated server.
+// The addresses claimed from pools and allocated from ranges for detached interfaces are released.
+// Does nothing if the provider spec has no interfaces or contains a private interface without a network.
+// The selectors of the provider spec are not resolved again if neither the interfaces of the provider spec nor the networks of the server changed since they were recorded.
+func (a *Actuator) reconcileServerInterfaces(ctx context.Context, sc cloudscale.ServerService, machine *machinev1beta1.Machine, mctx *machineContext, s *cloudscale.Server) (*cloudscale.Server, error) {
+	if mctx.spec.Interfaces == nil {
+		return s, nil
+	}
+	l := log.FromContext(ctx).WithName("Actuator.reconcileServerInterfaces")
+
+	current := make(map[string][]cloudscale.Interface)
+	var currentNetworks []string
+	for _, in := range s.Interfaces {
+		n := serverInterfaceNetwork(in)
+		current[n] = append(current[n], in)
+		currentNetworks = append(currentNetworks, n)
+	}
+	slices.Sort(currentNetworks)
+
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider status from machine: %w", err)
+	}
+	specHash, err := interfacesSpecHash(mctx.spec.Interfaces)
+	if err != nil {
+		return nil, err
+	}
+	if status.InterfacesSpecHash == specHash && slices.Equal(currentNetworks, statusNetworks(status.Interfaces)) {
+		return s, nil
+	}
+
+	desired, err := a.resolveInterfaces(ctx, mctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve interfaces: %w", err)
+	}
+
+	var desiredNetworks []string
+	for idx, in := range desired {
+		n := specInterfaceNetwork(in)
+		if n == "" {
+			l.Info("Not reconciling interfaces, private interface without network", "machine", machine.Name, "interface", idx)
+			return s, nil
+		}
+		desiredNetworks = append(desiredNetworks, n)
+	}
+	slices.Sort(desiredNetworks)
+	if slices.Equal(currentNetworks, desiredNetworks) {
+		if err := recordInterfaces(machine, mctx.spec.Interfaces, desired); err != nil {
+			return nil, fmt.Errorf("failed to record interfaces: %w", err)
+		}
+		return s, nil
+	}
+
+	// Only allocate addresses for interfaces of networks that are not attached yet
+	kept := make([]bool, len(desired))
+	remaining := make(map[string]int, len(current))
+	for n, ins := range current {
+		remaining[n] = len(ins)
+	}
+	for idx, in := range desired {
+		n := specInterfaceNetwork(in)
+		if remaining[n] > 0 {
+			remaining[n]--
+			kept[idx] = true
+			desired[idx].AddressesFromPools = nil
+			desired[idx].AddressFromRange = nil
+		}
+	}
+	desired, err = a.addressesFromPools(ctx, machine, desired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim addresses from pools: %w", err)
+	}
+	desired, err = a.addressesFromRanges(ctx, machine, mctx, desired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate addresses from ranges: %w", err)
+	}
+
+	reqs := make([]cloudscale.InterfaceRequest, 0, len(desired))
+	var attached []string
+	// inUse are the private addresses of the server after the update
+	inUse := make(map[string]struct{})
+	for idx, in := range desired {
+		n := specInterfaceNetwork(in)
+		if !kept[idx] {
+			attached = append(attached, n)
+			reqs = append(reqs, (*cloudscaleServerInterfacesFromProviderSpecInterfaces([]csv1beta1.Interface{in}))[0])
+			for _, addr := range in.Addresses {
+				inUse[addr.Address] = struct{}{}
+			}
+			continue
+		}
+		existing := current[n][0]
+		current[n] = current[n][1:]
+		req := cloudscale.InterfaceRequest{Network: n}
+		if n != "public" {
+			addrs := make([]cloudscale.AddressRequest, 0, len(existing.Addresses))
+			for _, addr := range existing.Addresses {
+				addrs = append(addrs, cloudscale.AddressRequest{Subnet: addr.Subnet.UUID, Address: addr.Address})
+				inUse[addr.Address] = struct{}{}
+			}
+			req.Addresses = &addrs
+		}
+		reqs = append(reqs, req)
+	}
+	var detached []string
+	for n, ins := range current {
+		for range ins {
+			detached = append(detached, n)
+		}
+	}
+	slices.Sort(detached)
+
+	if err := sc.Update(ctx, s.UUID, &cloudscale.ServerUpdateRequest{Interfaces: &reqs}); err != nil {
+		return nil, fmt.Errorf("failed to update interfaces of server %q: %w", s.UUID, err)
+	}
+	l.Info("Updated interfaces", "machine", machine.Name, "uuid", s.UUID, "attached", attached, "detached", detached)
+	a.eventRecorder.Eventf(machine, corev1.EventTypeNormal, eventReasonInterfacesUpdated, "Updated interfaces of server %q, attached %v, detached %v", s.UUID, attached, detached)
+
+	if err := recordInterfaces(machine, mctx.spec.Interfaces, desired); err != nil {
+		return nil, fmt.Errorf("failed to record interfaces: %w", err)
+	}
+	if len(detached) > 0 {
+		if err := a.releaseUnusedAddressesFromRanges(ctx, machine, inUse); err != nil {
+			return nil, fmt.Errorf("failed to release addresses of detached interfaces: %w", err)
+		}
+		if err := a.releaseUnusedAddressesFromPools(ctx, machine, inUse); err != nil {
+			return nil, fmt.Errorf("failed to release addresses of detached interfaces: %w", err)
+		}
+	}
+
+	updated, err := sc.Get(ctx, s.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server %q after updating interfaces: %w", s.UUID, err)
+	}
+	return updated, nil
+}
+
+// statusNetworks returns the sorted networks of the recorded interfaces, "public" for public interfaces and the network UUID for private interfaces.
+func statusNetworks(interfaces []csv1beta1.InterfaceStatus) []string {
+	networks := make([]string, 0, len(interfaces))
+	for _, in := range interfaces {
+		if in.Type == csv1beta1.InterfaceTypePublic {
+			networks = append(networks, "public")
+			continue
+		}
+		networks = append(networks, in.NetworkUUID)
+	}
+	slices.Sort(networks)
+	return networks
+}
+
+// serverInterfaceNetwork returns "public" for public interfaces and the network UUID for private interfaces of a cloudscale server.
+func serverInterfaceNetwork(in cloudscale.Interface) string {
+	if in.Type == "public" {
+		return "public"
+	}
+	return in.Network.UUID
+}
+
+// specInterfaceNetwork returns "public" for public interfaces and the network UUID for private interfaces of the provider spec.
+func specInterfaceNetwork(in csv1beta1.Interface) string {
+	if in.Type == csv1beta1.InterfaceTypePublic {
+		return "public"
+	}
+	return in.NetworkUUID
+}
@@ -0,0 +1,375 @@
+package machine
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ipamv1beta1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine/csmock"
+)
+
+func Test_Actuator_resolveInterfaces(t *testing.T) {
+	networks := []cloudscale.Network{
+		{UUID: "net-lpg-a", Name: "private", ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "lpg1"}}},
+		{UUID: "net-rma-a", Name: "private", ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "rma1"}}},
+		{UUID: "net-rma-b", Name: "storage", ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "rma1"}}},
+	}
+	subnets := []cloudscale.Subnet{
+		{UUID: "sub-a-1", CIDR: "10.0.0.0/24", Network: cloudscale.NetworkStub{UUID: "net-rma-a"}},
+		{UUID: "sub-a-2", CIDR: "10.0.1.0/24", Network: cloudscale.NetworkStub{UUID: "net-rma-a"}},
+		{UUID: "sub-b-1", CIDR: "10.0.0.0/24", Network: cloudscale.NetworkStub{UUID: "net-rma-b"}},
+	}
+
+	tcs := []struct {
+		name       string
+		interfaces []csv1beta1.Interface
+		apiMock    func(*csmock.MockNetworkService, *csmock.MockSubnetService)
+
+		expected       []csv1beta1.Interface
+		expectedErr    string
+		expectTerminal bool
+	}{
+		{
+			name: "no selectors",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-uuid", Addresses: []csv1beta1.Address{{SubnetUUID: "sub-uuid"}}},
+			},
+			apiMock: func(*csmock.MockNetworkService, *csmock.MockSubnetService) {},
+			expected: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-uuid", Addresses: []csv1beta1.Address{{SubnetUUID: "sub-uuid"}}},
+			},
+		},
+		{
+			name: "network by name and subnet by CIDR",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkName: "private", Addresses: []csv1beta1.Address{{SubnetCIDR: "10.0.0.0/24", Address: "10.0.0.10"}}},
+			},
+			apiMock: func(ns *csmock.MockNetworkService, ss *csmock.MockSubnetService) {
+				ns.EXPECT().List(gomock.Any()).Return(networks, nil)
+				ss.EXPECT().List(gomock.Any()).Return(subnets, nil)
+			},
+			expected: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-rma-a", Addresses: []csv1beta1.Address{{SubnetUUID: "sub-a-1", Address: "10.0.0.10"}}},
+			},
+		},
+		{
+			name: "network by tags",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkTags: map[string]string{"purpose": "storage"}},
+			},
+			apiMock: func(ns *csmock.MockNetworkService, ss *csmock.MockSubnetService) {
+				ns.EXPECT().List(gomock.Any(), gomock.Any()).Return(networks[2:], nil)
+			},
+			expected: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-rma-b"},
+			},
+		},
+		{
+			name: "network not found",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkName: "missing"},
+			},
+			apiMock: func(ns *csmock.MockNetworkService, ss *csmock.MockSubnetService) {
+				ns.EXPECT().List(gomock.Any()).Return(networks, nil)
+			},
+			expectedErr:    `interface 0: no network found in zone "rma1" with name "missing"`,
+			expectTerminal: true,
+		},
+		{
+			name: "ambiguous network",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkTags: map[string]string{"env": "prod"}},
+			},
+			apiMock: func(ns *csmock.MockNetworkService, ss *csmock.MockSubnetService) {
+				ns.EXPECT().List(gomock.Any(), gomock.Any()).Return(networks, nil)
+			},
+			expectedErr:    "2 networks found",
+			expectTerminal: true,
+		},
+		{
+			name: "ambiguous subnet without network",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, Addresses: []csv1beta1.Address{{SubnetCIDR: "10.0.0.0/24"}}},
+			},
+			apiMock: func(ns *csmock.MockNetworkService, ss *csmock.MockSubnetService) {
+				ss.EXPECT().List(gomock.Any()).Return(subnets, nil)
+			},
+			expectedErr:    "interface 0, address 0: 2 subnets found",
+			expectTerminal: true,
+		},
+		{
+			name: "selector and UUID",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-uuid", NetworkName: "private"},
+			},
+			apiMock:        func(*csmock.MockNetworkService, *csmock.MockSubnetService) {},
+			expectedErr:    "mutually exclusive",
+			expectTerminal: true,
+		},
+		{
+			name: "API error",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkName: "private"},
+			},
+			apiMock: func(ns *csmock.MockNetworkService, ss *csmock.MockSubnetService) {
+				ns.EXPECT().List(gomock.Any()).Return(nil, errors.New("api down"))
+			},
+			expectedErr: "failed to list networks: api down",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			ns := csmock.NewMockNetworkService(ctrl)
+			ss := csmock.NewMockSubnetService(ctrl)
+			tc.apiMock(ns, ss)
+
+			a := &Actuator{
+				networkClientFactory: func(string) cloudscale.NetworkService { return ns },
+				subnetClientFactory:  func(string) cloudscale.SubnetService { return ss },
+			}
+			mctx := &machineContext{spec: csv1beta1.CloudscaleMachineProviderSpec{
+				Zone:       "rma1",
+				Interfaces: tc.interfaces,
+			}}
+
+			resolved, err := a.resolveInterfaces(t.Context(), mctx)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				var merr *machinecontroller.MachineError
+				assert.Equal(t, tc.expectTerminal, errors.As(err, &merr), "terminal error")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, resolved)
+		})
+	}
+}
+
+func Test_interfaceStatuses(t *testing.T) {
+	assert.Equal(t, []csv1beta1.InterfaceStatus{
+		{Type: csv1beta1.InterfaceTypePublic},
+		{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-uuid", SubnetUUIDs: []string{"sub-1", "sub-2"}},
+	}, interfaceStatuses([]csv1beta1.Interface{
+		{Type: csv1beta1.InterfaceTypePublic},
+		{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-uuid", Addresses: []csv1beta1.Address{{SubnetUUID: "sub-1"}, {SubnetUUID: "sub-2"}}},
+	}))
+}
+
+func Test_Actuator_reconcileServerInterfaces(t *testing.T) {
+	server := &cloudscale.Server{
+		UUID: "server-uuid",
+		Interfaces: []cloudscale.Interface{
+			{Type: "public", Addresses: []cloudscale.Address{{Address: "203.0.113.5"}}},
+			{Type: "private", Network: cloudscale.NetworkStub{UUID: "net-a"}, Addresses: []cloudscale.Address{{Address: "10.0.0.5", Subnet: cloudscale.SubnetStub{UUID: "sub-a"}}}},
+		},
+	}
+
+	tcs := []struct {
+		name       string
+		interfaces []csv1beta1.Interface
+		// recorded are the interfaces recorded in the provider status
+		recorded []csv1beta1.Interface
+
+		expectedReq   *[]cloudscale.InterfaceRequest
+		expectedEvent string
+		// expectedRecorded are the interfaces expected in the provider status, nil if the status should not be updated
+		expectedRecorded []csv1beta1.Interface
+	}{
+		{
+			name: "unchanged",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-a"},
+				{Type: csv1beta1.InterfaceTypePublic},
+			},
+			expectedRecorded: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-a"},
+				{Type: csv1beta1.InterfaceTypePublic},
+			},
+		},
+		{
+			name: "unchanged since recorded",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+				// Resolving the selector would fail, there is no network client
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkName: "private"},
+			},
+			recorded: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-a"},
+			},
+		},
+		{
+			name: "attach network",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-a", Addresses: []csv1beta1.Address{{Address: "10.0.0.99"}}},
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-b", Addresses: []csv1beta1.Address{{Address: "10.1.0.5"}}},
+			},
+			expectedReq: &[]cloudscale.InterfaceRequest{
+				{Network: "public"},
+				{Network: "net-a", Addresses: &[]cloudscale.AddressRequest{{Subnet: "sub-a", Address: "10.0.0.5"}}},
+				{Network: "net-b", Addresses: &[]cloudscale.AddressRequest{{Address: "10.1.0.5"}}},
+			},
+			expectedEvent: "Normal InterfacesUpdated Updated interfaces of server \"server-uuid\", attached [net-b], detached []",
+			expectedRecorded: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-a", Addresses: []csv1beta1.Address{{Address: "10.0.0.99"}}},
+				{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-b", Addresses: []csv1beta1.Address{{Address: "10.1.0.5"}}},
+			},
+		},
+		{
+			name: "detach network",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+			},
+			expectedReq: &[]cloudscale.InterfaceRequest{
+				{Network: "public"},
+			},
+			expectedEvent: "Normal InterfacesUpdated Updated interfaces of server \"server-uuid\", attached [], detached [net-a]",
+			expectedRecorded: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+			},
+		},
+		{
+			name: "private interface without network",
+			interfaces: []csv1beta1.Interface{
+				{Type: csv1beta1.InterfaceTypePublic},
+				{Type: csv1beta1.InterfaceTypePrivate},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			ss := csmock.NewMockServerService(ctrl)
+			if tc.expectedReq != nil {
+				ss.EXPECT().Update(gomock.Any(), "server-uuid", &cloudscale.ServerUpdateRequest{Interfaces: tc.expectedReq}).Return(nil)
+				ss.EXPECT().Get(gomock.Any(), "server-uuid").Return(server, nil)
+			}
+			recorder := record.NewFakeRecorder(10)
+			machine := &machinev1beta1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "openshift-machine-api"}}
+			if tc.recorded != nil {
+				require.NoError(t, recordInterfaces(machine, tc.interfaces, tc.recorded))
+			}
+			a := &Actuator{eventRecorder: recorder, k8sClient: newFakeClient(t, machine)}
+			mctx := &machineContext{spec: csv1beta1.CloudscaleMachineProviderSpec{Interfaces: tc.interfaces}}
+			before := machine.DeepCopy()
+
+			updated, err := a.reconcileServerInterfaces(t.Context(), ss, machine, mctx, server)
+			require.NoError(t, err)
+			assert.Equal(t, server, updated)
+
+			events := drainEvents(recorder)
+			if tc.expectedEvent == "" {
+				assert.Empty(t, events)
+			} else {
+				assert.Equal(t, []string{tc.expectedEvent}, events)
+			}
+			if tc.expectedRecorded == nil {
+				assert.Equal(t, before.Status.ProviderStatus, machine.Status.ProviderStatus, "provider status should not be updated")
+				return
+			}
+			status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+			require.NoError(t, err)
+			assert.Equal(t, interfaceStatuses(tc.expectedRecorded), status.Interfaces)
+			specHash, err := interfacesSpecHash(tc.interfaces)
+			require.NoError(t, err)
+			assert.Equal(t, specHash, status.InterfacesSpecHash)
+		})
+	}
+}
+
+func Test_Actuator_reconcileServerInterfaces_ReleaseDetached(t *testing.T) {
+	server := &cloudscale.Server{
+		UUID: "server-uuid",
+		Interfaces: []cloudscale.Interface{
+			{Type: "public", Addresses: []cloudscale.Address{{Address: "203.0.113.5"}}},
+			{Type: "private", Network: cloudscale.NetworkStub{UUID: "net-a"}, Addresses: []cloudscale.Address{{Address: "10.0.0.5"}}},
+			{Type: "private", Network: cloudscale.NetworkStub{UUID: "net-b"}, Addresses: []cloudscale.Address{{Address: "10.1.0.5"}}},
+			{Type: "private", Network: cloudscale.NetworkStub{UUID: "net-c"}, Addresses: []cloudscale.Address{{Address: "10.2.0.5"}}},
+		},
+	}
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-test",
+			Namespace: "openshift-machine-api",
+			UID:       "machine-uid",
+			Annotations: map[string]string{
+				// net-a was allocated from a range, net-b claimed from a pool
+				csv1beta1.AllocatedAddressesAnnotation: `{"1":"10.0.0.5"}`,
+			},
+		},
+	}
+	otherMachine := &machinev1beta1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "app-other", Namespace: "openshift-machine-api", UID: "other-uid"}}
+	claim := func(name, address string, owner *machinev1beta1.Machine) (*ipamv1beta1.IPAddressClaim, *ipamv1beta1.IPAddress) {
+		return &ipamv1beta1.IPAddressClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       machine.Namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owner, machinev1beta1.GroupVersion.WithKind("Machine"))},
+				Finalizers:      []string{machinev1beta1.IPClaimProtectionFinalizer},
+			},
+			Status: ipamv1beta1.IPAddressClaimStatus{AddressRef: corev1.LocalObjectReference{Name: name}},
+		}, &ipamv1beta1.IPAddress{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: machine.Namespace},
+			Spec:       ipamv1beta1.IPAddressSpec{Address: address},
+		}
+	}
+	detachedClaim, detachedAddr := claim("app-test-claim-2-0", "10.1.0.5", machine)
+	keptClaim, keptAddr := claim("app-test-claim-3-0", "10.2.0.5", machine)
+	otherClaim, otherAddr := claim("app-other-claim-2-0", "10.1.0.6", otherMachine)
+	c := newFakeClient(t, machine, detachedClaim, detachedAddr, keptClaim, keptAddr, otherClaim, otherAddr)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ss := csmock.NewMockServerService(ctrl)
+	ss.EXPECT().Update(gomock.Any(), "server-uuid", gomock.Any()).Return(nil)
+	ss.EXPECT().Get(gomock.Any(), "server-uuid").Return(server, nil)
+
+	a := &Actuator{eventRecorder: record.NewFakeRecorder(10), k8sClient: c}
+	a.addressRangeAllocator.reserve(netip.MustParseAddr("10.0.0.5"), client.ObjectKeyFromObject(machine).String())
+	mctx := &machineContext{spec: csv1beta1.CloudscaleMachineProviderSpec{Interfaces: []csv1beta1.Interface{
+		{Type: csv1beta1.InterfaceTypePublic},
+		{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "net-c"},
+	}}}
+
+	_, err := a.reconcileServerInterfaces(t.Context(), ss, machine, mctx, server)
+	require.NoError(t, err)
+
+	allocated, err := allocatedAddresses(machine)
+	require.NoError(t, err)
+	assert.Empty(t, allocated, "the range allocation of the detached interface should be released")
+	assert.Empty(t, a.addressRangeAllocator.reserved, "the reservation of the detached interface should be released")
+
+	var updated machinev1beta1.Machine
+	require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(machine), &updated))
+	assert.Equal(t, "{}", updated.Annotations[csv1beta1.AllocatedAddressesAnnotation])
+
+	err = c.Get(t.Context(), client.ObjectKeyFromObject(detachedClaim), &ipamv1beta1.IPAddressClaim{})
+	assert.True(t, apierrors.IsNotFound(err), "the claim of the detached interface should be deleted, got %v", err)
+	require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(keptClaim), &ipamv1beta1.IPAddressClaim{}), "the claim of the kept interface should not be deleted")
+	require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(otherClaim), &ipamv1beta1.IPAddressClaim{}), "claims of other machines should not be deleted")
+}
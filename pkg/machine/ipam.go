@@ -0,0 +1,126 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/openshift/machine-api-operator/pkg/util/ipam"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ipamv1beta1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// ipAddressClaimRequeueAfter is the time to wait before checking again if the IPAddressClaims of a machine are bound.
+const ipAddressClaimRequeueAfter = 10 * time.Second
+
+// errIPAddressClaimsNotBound is wrapped by the error returned while the IPAddressClaims of a machine are not bound yet.
+// The machine is requeued without recording a failure event.
+var errIPAddressClaimsNotBound = errors.New("IPAddressClaims are not bound yet")
+
+// addressesFromPools claims the addresses of the AddressesFromPools of the interfaces and returns the interfaces with the claimed addresses appended to Addresses.
+// Returns an error wrapping errIPAddressClaimsNotBound and a RequeueAfterError if any claim is not yet bound to an IPAddress.
+func (a *Actuator) addressesFromPools(ctx context.Context, machine *machinev1beta1.Machine, interfaces []csv1beta1.Interface) ([]csv1beta1.Interface, error) {
+	if !hasAddressesFromPools(interfaces) {
+		return interfaces, nil
+	}
+	l := log.FromContext(ctx).WithName("Actuator.addressesFromPools")
+
+	outstanding := 0
+	claimed := make([]csv1beta1.Interface, 0, len(interfaces))
+	for idx, in := range interfaces {
+		r := *in.DeepCopy()
+		for poolIdx, pool := range in.AddressesFromPools {
+			if in.Type != csv1beta1.InterfaceTypePrivate {
+				return nil, machinecontroller.InvalidMachineConfiguration("interface %d: addressesFromPools can only be set on private interfaces", idx)
+			}
+			claimName := ipam.GetIPAddressClaimName(machine, idx, poolIdx)
+			claim, err := ipam.EnsureIPAddressClaim(ctx, a.k8sClient, claimName, machine, pool)
+			if err != nil {
+				return nil, fmt.Errorf("failed to ensure IPAddressClaim %q: %w", claimName, err)
+			}
+			if claim.Status.AddressRef.Name == "" {
+				outstanding++
+				continue
+			}
+			addr, err := ipam.RetrieveBoundIPAddress(ctx, a.k8sClient, machine, claimName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve IPAddress of IPAddressClaim %q: %w", claimName, err)
+			}
+			r.Addresses = append(r.Addresses, csv1beta1.Address{Address: addr.Spec.Address})
+		}
+		claimed = append(claimed, r)
+	}
+
+	if outstanding > 0 {
+		l.Info("Waiting for IPAddressClaims to be bound", "machine", machine.Name, "outstanding", outstanding)
+		return nil, fmt.Errorf("%w: %d outstanding for machine %q: %w",
+			errIPAddressClaimsNotBound, outstanding, machine.Name, &machinecontroller.RequeueAfterError{RequeueAfter: ipAddressClaimRequeueAfter})
+	}
+	return claimed, nil
+}
+
+// releaseAddressesFromPools removes the protection finalizer from all IPAddressClaims controlled by the machine.
+// The claims are looked up by their owner reference, not by the current spec, so claims of zone interfaces or of interfaces removed from the spec are released as well.
+// The claims are garbage collected with the machine.
+func (a *Actuator) releaseAddressesFromPools(ctx context.Context, machine *machinev1beta1.Machine) error {
+	err := ipam.RemoveFinalizersForIPAddressClaims(ctx, a.k8sClient, *machine)
+	if meta.IsNoMatchError(err) {
+		// The IPAM CRDs are not installed, there are no claims to release
+		return nil
+	}
+	return err
+}
+
+// releaseUnusedAddressesFromPools deletes the IPAddressClaims controlled by the machine whose address is not in use.
+// Used to release the addresses of detached interfaces.
+func (a *Actuator) releaseUnusedAddressesFromPools(ctx context.Context, machine *machinev1beta1.Machine, inUse map[string]struct{}) error {
+	var claims ipamv1beta1.IPAddressClaimList
+	if err := a.k8sClient.List(ctx, &claims, client.InNamespace(machine.Namespace)); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list IPAddressClaims: %w", err)
+	}
+	for _, claim := range claims.Items {
+		if !metav1.IsControlledBy(&claim, machine) {
+			continue
+		}
+		if claim.Status.AddressRef.Name != "" {
+			var addr ipamv1beta1.IPAddress
+			if err := a.k8sClient.Get(ctx, client.ObjectKey{Namespace: claim.Namespace, Name: claim.Status.AddressRef.Name}, &addr); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("failed to get IPAddress of IPAddressClaim %q: %w", claim.Name, err)
+			}
+			if _, ok := inUse[addr.Spec.Address]; ok {
+				continue
+			}
+		}
+		if controllerutil.RemoveFinalizer(&claim, machinev1beta1.IPClaimProtectionFinalizer) {
+			if err := a.k8sClient.Update(ctx, &claim); err != nil {
+				return fmt.Errorf("failed to remove finalizer from IPAddressClaim %q: %w", claim.Name, err)
+			}
+		}
+		if err := a.k8sClient.Delete(ctx, &claim); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete IPAddressClaim %q: %w", claim.Name, err)
+		}
+		log.FromContext(ctx).WithName("Actuator.releaseUnusedAddressesFromPools").Info("Released IPAddressClaim", "machine", machine.Name, "claim", claim.Name)
+	}
+	return nil
+}
+
+func hasAddressesFromPools(interfaces []csv1beta1.Interface) bool {
+	for _, in := range interfaces {
+		if len(in.AddressesFromPools) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ipamv1beta1 "sigs.k8s.io/cluster-api/exp/ipam/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+func Test_Actuator_addressesFromPools(t *testing.T) {
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-test",
+			Namespace: "openshift-machine-api",
+			UID:       "machine-uid",
+		},
+	}
+	pool := machinev1beta1.AddressesFromPool{
+		Group:    "ipam.cluster.x-k8s.io",
+		Resource: "InClusterIPPool",
+		Name:     "private",
+	}
+	interfaces := []csv1beta1.Interface{
+		{Type: csv1beta1.InterfaceTypePublic},
+		{
+			Type:               csv1beta1.InterfaceTypePrivate,
+			NetworkUUID:        "net-uuid",
+			Addresses:          []csv1beta1.Address{{Address: "172.16.0.5"}},
+			AddressesFromPools: []machinev1beta1.AddressesFromPool{pool},
+		},
+	}
+
+	t.Run("no pools", func(t *testing.T) {
+		a := &Actuator{k8sClient: newFakeClient(t)}
+		noPools := []csv1beta1.Interface{{Type: csv1beta1.InterfaceTypePublic}}
+
+		claimed, err := a.addressesFromPools(t.Context(), machine, noPools)
+		require.NoError(t, err)
+		assert.Equal(t, noPools, claimed)
+	})
+
+	t.Run("claim not bound", func(t *testing.T) {
+		c := newFakeClient(t)
+		a := &Actuator{k8sClient: c}
+
+		_, err := a.addressesFromPools(t.Context(), machine, interfaces)
+		var requeue *machinecontroller.RequeueAfterError
+		require.True(t, errors.As(err, &requeue), "expected RequeueAfterError, got %v", err)
+		assert.Equal(t, ipAddressClaimRequeueAfter, requeue.RequeueAfter)
+		assert.True(t, isExpectedRequeue(err), "waiting for the claims should not be reported as a failure")
+
+		var claim ipamv1beta1.IPAddressClaim
+		require.NoError(t, c.Get(t.Context(), client.ObjectKey{Namespace: machine.Namespace, Name: "app-test-claim-1-0"}, &claim))
+		assert.Equal(t, "private", claim.Spec.PoolRef.Name)
+		assert.Contains(t, claim.Finalizers, machinev1beta1.IPClaimProtectionFinalizer)
+		assert.True(t, metav1.IsControlledBy(&claim, machine), "claim should be controlled by the machine")
+	})
+
+	t.Run("claim bound", func(t *testing.T) {
+		claim := &ipamv1beta1.IPAddressClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "app-test-claim-1-0",
+				Namespace:       machine.Namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(machine, machinev1beta1.GroupVersion.WithKind("Machine"))},
+			},
+			Status: ipamv1beta1.IPAddressClaimStatus{
+				AddressRef: corev1.LocalObjectReference{Name: "private-1"},
+			},
+		}
+		address := &ipamv1beta1.IPAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "private-1",
+				Namespace: machine.Namespace,
+			},
+			Spec: ipamv1beta1.IPAddressSpec{
+				Address: "172.16.0.10",
+				Prefix:  24,
+			},
+		}
+		a := &Actuator{k8sClient: newFakeClient(t, claim, address)}
+
+		claimed, err := a.addressesFromPools(t.Context(), machine, interfaces)
+		require.NoError(t, err)
+		require.Len(t, claimed, 2)
+		assert.Equal(t, []csv1beta1.Address{{Address: "172.16.0.5"}, {Address: "172.16.0.10"}}, claimed[1].Addresses)
+		assert.Equal(t, []csv1beta1.Address{{Address: "172.16.0.5"}}, interfaces[1].Addresses, "spec interfaces must not be modified")
+	})
+
+	t.Run("public interface", func(t *testing.T) {
+		a := &Actuator{k8sClient: newFakeClient(t)}
+
+		_, err := a.addressesFromPools(t.Context(), machine, []csv1beta1.Interface{
+			{Type: csv1beta1.InterfaceTypePublic, AddressesFromPools: []machinev1beta1.AddressesFromPool{pool}},
+		})
+		var merr *machinecontroller.MachineError
+		require.True(t, errors.As(err, &merr), "expected terminal error, got %v", err)
+	})
+}
+
+func Test_Actuator_releaseAddressesFromPools(t *testing.T) {
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-test",
+			Namespace: "openshift-machine-api",
+			UID:       "machine-uid",
+		},
+	}
+	otherMachine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-other",
+			Namespace: "openshift-machine-api",
+			UID:       "other-machine-uid",
+		},
+	}
+	claim := func(name string, owner *machinev1beta1.Machine) *ipamv1beta1.IPAddressClaim {
+		return &ipamv1beta1.IPAddressClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       machine.Namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owner, machinev1beta1.GroupVersion.WithKind("Machine"))},
+				Finalizers:      []string{machinev1beta1.IPClaimProtectionFinalizer},
+			},
+		}
+	}
+	// The claims are released regardless of the interfaces of the spec, e.g. for interfaces of a zone in Zones.
+	claims := []*ipamv1beta1.IPAddressClaim{
+		claim("app-test-claim-0-0", machine),
+		claim("app-test-claim-1-0", machine),
+	}
+	otherClaim := claim("app-other-claim-0-0", otherMachine)
+
+	t.Run("release claims", func(t *testing.T) {
+		c := newFakeClient(t, claims[0], claims[1], otherClaim)
+		a := &Actuator{k8sClient: c}
+
+		require.NoError(t, a.releaseAddressesFromPools(t.Context(), machine))
+
+		for _, cl := range claims {
+			var updated ipamv1beta1.IPAddressClaim
+			require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(cl), &updated))
+			assert.NotContains(t, updated.Finalizers, machinev1beta1.IPClaimProtectionFinalizer)
+		}
+		var updated ipamv1beta1.IPAddressClaim
+		require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(otherClaim), &updated))
+		assert.Contains(t, updated.Finalizers, machinev1beta1.IPClaimProtectionFinalizer, "claims of other machines should not be released")
+	})
+
+	t.Run("IPAM CRDs not installed", func(t *testing.T) {
+		c := fake.NewClientBuilder().
+			WithScheme(testScheme).
+			WithInterceptorFuncs(interceptor.Funcs{
+				List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+					return &meta.NoKindMatchError{GroupKind: ipamv1beta1.GroupVersion.WithKind("IPAddressClaim").GroupKind()}
+				},
+			}).
+			Build()
+		a := &Actuator{k8sClient: c}
+
+		require.NoError(t, a.releaseAddressesFromPools(t.Context(), machine))
+	})
+}
@@ -3,3 +3,5 @@ package machine
 //go:generate go run go.uber.org/mock/mockgen -destination=./csmock/server_service.go -package csmock github.com/cloudscale-ch/cloudscale-go-sdk/v6 ServerService
 //go:generate go run go.uber.org/mock/mockgen -destination=./csmock/server_group_service.go -package csmock github.com/cloudscale-ch/cloudscale-go-sdk/v6 ServerGroupService
 //go:generate go run go.uber.org/mock/mockgen -destination=./csmock/volume_service.go -package csmock github.com/cloudscale-ch/cloudscale-go-sdk/v6 VolumeService
+//go:generate go run go.uber.org/mock/mockgen -destination=./csmock/network_service.go -package csmock github.com/cloudscale-ch/cloudscale-go-sdk/v6 NetworkService
+//go:generate go run go.uber.org/mock/mockgen -destination=./csmock/subnet_service.go -package csmock github.com/cloudscale-ch/cloudscale-go-sdk/v6 SubnetService
@@ -0,0 +1,227 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+const (
+	// DefaultQuotaCacheDuration is the default duration the resource usage of a cloudscale project is cached for the quota check.
+	DefaultQuotaCacheDuration = 30 * time.Second
+
+	// defaultRootVolumeSizeGB is the size of the root volume the cloudscale API uses if RootVolumeSizeGB is not set.
+	defaultRootVolumeSizeGB = 10
+	// cloudscaleVolumeTypeSSD is the type of SSD volumes in the cloudscale API.
+	cloudscaleVolumeTypeSSD = "ssd"
+
+	quotaExceededMinRequeueAfter = 30 * time.Second
+	quotaExceededMaxRequeueAfter = 10 * time.Minute
+
+	conditionReasonQuotaExceeded = "QuotaExceeded"
+	conditionReasonWithinQuota   = "WithinQuota"
+)
+
+// flavorSlug matches the cloudscale flavor slugs, e.g. flex-8-4 for a flavor with 8 GB of memory and 4 vCPUs.
+var flavorSlug = regexp.MustCompile(`^[a-z0-9]+-(\d+)-(\d+)(?:-|$)`)
+
+// Quota is the quota of the cloudscale projects the provider creates servers in.
+// The cloudscale API does not expose the quota of a project, so it is configured on the provider.
+// The usage is calculated from the servers and volumes of the project of the API token.
+// Zero values disable the check of the resource.
+type Quota struct {
+	// VCPUs is the maximum number of vCPUs of all servers of a project.
+	VCPUs int
+	// MemoryGB is the maximum memory in GB of all servers of a project.
+	MemoryGB int
+	// SSDVolumeGB is the maximum size in GB of all SSD volumes of a project, including root volumes.
+	SSDVolumeGB int
+	// CacheDuration is the duration the usage of a project is cached.
+	// Defaults to DefaultQuotaCacheDuration.
+	CacheDuration time.Duration
+}
+
+func (q Quota) enabled() bool {
+	return q.VCPUs > 0 || q.MemoryGB > 0 || q.SSDVolumeGB > 0
+}
+
+// quotaUsage is the resource usage of a cloudscale project.
+type quotaUsage struct {
+	VCPUs       int
+	MemoryGB    int
+	SSDVolumeGB int
+}
+
+// quotaUsageCache caches the resource usage of the cloudscale projects per API token.
+type quotaUsageCache struct {
+	mu      sync.Mutex
+	entries map[string]quotaUsageCacheEntry
+}
+
+type quotaUsageCacheEntry struct {
+	usage     quotaUsage
+	fetchedAt time.Time
+}
+
+// get returns the cached usage for the token if it is younger than maxAge, otherwise the usage returned by fetch.
+func (c *quotaUsageCache) get(token string, maxAge time.Duration, fetch func() (quotaUsage, error)) (quotaUsage, error) {
+	c.mu.Lock()
+	e, ok := c.entries[token]
+	c.mu.Unlock()
+	if ok && time.Since(e.fetchedAt) < maxAge {
+		return e.usage, nil
+	}
+
+	usage, err := fetch()
+	if err != nil {
+		return quotaUsage{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]quotaUsageCacheEntry)
+	}
+	c.entries[token] = quotaUsageCacheEntry{usage: usage, fetchedAt: time.Now()}
+	return usage, nil
+}
+
+// invalidate removes the cached usage for the token, so the next check sees the servers created since.
+func (c *quotaUsageCache) invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, token)
+}
+
+// errQuotaExceeded is wrapped by the errors returned from Create while no flavor of the machine fits into the quota of the project.
+// The machine is requeued without recording a failure event.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// checkQuota checks whether servers with the flavor and the FlavorFallbacks and the root volume of the spec fit into the quota of the project
+// and sets the QuotaExceeded condition accordingly.
+// Returns the flavors fitting into the quota in the order they are tried. Returns all flavors if no quota is configured.
+// If no flavor fits into the quota, the machine is patched and an error wrapping errQuotaExceeded and a machinecontroller.RequeueAfterError is returned.
+// The requeue delay grows with the time the quota has been exceeded.
+// The vCPUs and memory of flavors with an unknown slug format are not checked.
+func (a *Actuator) checkQuota(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext) ([]string, error) {
+	flavors := serverFlavors(mctx.spec)
+	if !a.quota.enabled() {
+		return flavors, nil
+	}
+
+	usage, err := a.quotaUsage.get(mctx.token, a.quota.CacheDuration, func() (quotaUsage, error) {
+		return a.fetchQuotaUsage(ctx, mctx.token)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource usage of the cloudscale project: %w", err)
+	}
+
+	var fitting, exceeded []string
+	for _, flavor := range flavors {
+		shortfalls := a.quotaShortfalls(usage, flavor, mctx.spec.RootVolumeSizeGB)
+		if len(shortfalls) > 0 {
+			exceeded = append(exceeded, fmt.Sprintf("A server with flavor %q exceeds the quota of the project: %s", flavor, strings.Join(shortfalls, ", ")))
+			continue
+		}
+		fitting = append(fitting, flavor)
+	}
+
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider status from machine: %w", err)
+	}
+	requeueAfter := quotaExceededMinRequeueAfter
+	if prev := meta.FindStatusCondition(status.Conditions, csv1beta1.QuotaExceededCondition); prev != nil && prev.Status == metav1.ConditionTrue {
+		requeueAfter = min(max(time.Since(prev.LastTransitionTime.Time), quotaExceededMinRequeueAfter), quotaExceededMaxRequeueAfter)
+	}
+
+	cond := metav1.Condition{
+		Type:               csv1beta1.QuotaExceededCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             conditionReasonQuotaExceeded,
+		Message:            strings.Join(exceeded, "; "),
+		ObservedGeneration: machine.Generation,
+	}
+	if len(fitting) > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = conditionReasonWithinQuota
+		cond.Message = fmt.Sprintf("A server with flavor %q fits into the quota of the project", fitting[0])
+		if len(exceeded) > 0 {
+			cond.Message = fmt.Sprintf("%s; %s", cond.Message, strings.Join(exceeded, "; "))
+			log.FromContext(ctx).WithName("Actuator.checkQuota").Info("Skipping flavors exceeding the quota", "machine", machine.Name, "flavors", flavors, "fitting", fitting)
+		}
+	}
+	if err := setProviderStatusCondition(machine, cond); err != nil {
+		return nil, fmt.Errorf("failed to set quota condition on machine %q: %w", machine.Name, err)
+	}
+
+	if len(fitting) > 0 {
+		return fitting, nil
+	}
+
+	if err := a.patchMachine(ctx, mctx.machine, machine); err != nil {
+		return nil, fmt.Errorf("failed to patch machine %q: %w", machine.Name, err)
+	}
+	return nil, fmt.Errorf("%w: %s, retrying in %s: %w", errQuotaExceeded, cond.Message, requeueAfter, &machinecontroller.RequeueAfterError{RequeueAfter: requeueAfter})
+}
+
+// quotaShortfalls returns the resources missing in the quota to create a server with the given flavor and root volume size.
+func (a *Actuator) quotaShortfalls(usage quotaUsage, flavor string, rootVolumeSizeGB int) []string {
+	var requested quotaUsage
+	if m := flavorSlug.FindStringSubmatch(flavor); m != nil {
+		requested.MemoryGB, _ = strconv.Atoi(m[1])
+		requested.VCPUs, _ = strconv.Atoi(m[2])
+	}
+	requested.SSDVolumeGB = rootVolumeSizeGB
+	if requested.SSDVolumeGB == 0 {
+		requested.SSDVolumeGB = defaultRootVolumeSizeGB
+	}
+
+	var shortfalls []string
+	shortfall := func(unit string, limit, used, requested int) {
+		if limit > 0 && requested > 0 && used+requested > limit {
+			shortfalls = append(shortfalls, fmt.Sprintf("%d %s missing (%d requested, %d of %d used)", used+requested-limit, unit, requested, used, limit))
+		}
+	}
+	shortfall("vCPUs", a.quota.VCPUs, usage.VCPUs, requested.VCPUs)
+	shortfall("GB memory", a.quota.MemoryGB, usage.MemoryGB, requested.MemoryGB)
+	shortfall("GB SSD volumes", a.quota.SSDVolumeGB, usage.SSDVolumeGB, requested.SSDVolumeGB)
+	return shortfalls
+}
+
+// fetchQuotaUsage sums up the vCPUs and memory of all servers and the size of all SSD volumes of the project of the token.
+func (a *Actuator) fetchQuotaUsage(ctx context.Context, token string) (quotaUsage, error) {
+	servers, err := a.serverClientFactory(token).List(ctx)
+	if err != nil {
+		return quotaUsage{}, fmt.Errorf("failed to list servers: %w", err)
+	}
+	volumes, err := a.volumeClientFactory(token).List(ctx)
+	if err != nil {
+		return quotaUsage{}, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	var usage quotaUsage
+	for _, s := range servers {
+		usage.VCPUs += s.Flavor.VCPUCount
+		usage.MemoryGB += s.Flavor.MemoryGB
+	}
+	for _, v := range volumes {
+		if v.Type == cloudscaleVolumeTypeSSD {
+			usage.SSDVolumeGB += v.SizeGB
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,197 @@
+package machine
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine/csmock"
+)
+
+func Test_Actuator_Create_Quota(t *testing.T) {
+	servers := []cloudscale.Server{
+		{Flavor: cloudscale.Flavor{Slug: "plus-16-4", VCPUCount: 4, MemoryGB: 16}},
+		{Flavor: cloudscale.Flavor{Slug: "flex-8-2", VCPUCount: 2, MemoryGB: 8}},
+	}
+	volumes := []cloudscale.Volume{
+		{Type: "ssd", SizeGB: 100},
+		{Type: "ssd", SizeGB: 50},
+		{Type: "bulk", SizeGB: 1000},
+	}
+
+	tcs := []struct {
+		name            string
+		quota           Quota
+		flavor          string
+		flavorFallbacks []string
+		rootGB          int
+		created         string
+
+		expectedStatus  metav1.ConditionStatus
+		expectedMessage string
+	}{
+		{
+			name:            "within quota",
+			quota:           Quota{VCPUs: 10, MemoryGB: 40, SSDVolumeGB: 200},
+			flavor:          "plus-16-4",
+			rootGB:          50,
+			created:         "plus-16-4",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedMessage: `A server with flavor "plus-16-4" fits into the quota of the project`,
+		},
+		{
+			name:            "vCPUs and memory exceeded",
+			quota:           Quota{VCPUs: 8, MemoryGB: 32, SSDVolumeGB: 200},
+			flavor:          "plus-16-4",
+			expectedStatus:  metav1.ConditionTrue,
+			expectedMessage: `A server with flavor "plus-16-4" exceeds the quota of the project: 2 vCPUs missing (4 requested, 6 of 8 used), 8 GB memory missing (16 requested, 24 of 32 used)`,
+		},
+		{
+			name:            "default root volume exceeds SSD quota",
+			quota:           Quota{SSDVolumeGB: 155},
+			flavor:          "flex-4-1",
+			expectedStatus:  metav1.ConditionTrue,
+			expectedMessage: `A server with flavor "flex-4-1" exceeds the quota of the project: 5 GB SSD volumes missing (10 requested, 150 of 155 used)`,
+		},
+		{
+			name:            "unknown flavor format only checks volumes",
+			quota:           Quota{VCPUs: 1, MemoryGB: 1, SSDVolumeGB: 200},
+			flavor:          "custom",
+			created:         "custom",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedMessage: `A server with flavor "custom" fits into the quota of the project`,
+		},
+		{
+			name:            "falls back to flavor within quota",
+			quota:           Quota{VCPUs: 8, MemoryGB: 32},
+			flavor:          "plus-16-4",
+			flavorFallbacks: []string{"plus-8-4", "flex-4-1", "flex-8-2"},
+			created:         "flex-4-1",
+			expectedStatus:  metav1.ConditionFalse,
+			expectedMessage: `A server with flavor "flex-4-1" fits into the quota of the project; A server with flavor "plus-16-4" exceeds the quota of the project: 2 vCPUs missing (4 requested, 6 of 8 used), 8 GB memory missing (16 requested, 24 of 32 used); A server with flavor "plus-8-4" exceeds the quota of the project: 2 vCPUs missing (4 requested, 6 of 8 used)`,
+		},
+		{
+			name:            "all flavors exceed quota",
+			quota:           Quota{VCPUs: 7},
+			flavor:          "plus-16-4",
+			flavorFallbacks: []string{"flex-8-2"},
+			expectedStatus:  metav1.ConditionTrue,
+			expectedMessage: `A server with flavor "plus-16-4" exceeds the quota of the project: 3 vCPUs missing (4 requested, 6 of 7 used); A server with flavor "flex-8-2" exceeds the quota of the project: 1 vCPUs missing (2 requested, 6 of 7 used)`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app-test",
+					Labels: map[string]string{
+						machineClusterIDLabelName: "cluster-id",
+					},
+				},
+			}
+			setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{
+				Zone:             "rma1",
+				Flavor:           tc.flavor,
+				FlavorFallbacks:  tc.flavorFallbacks,
+				RootVolumeSizeGB: tc.rootGB,
+			})
+
+			c := newFakeClient(t, machine)
+			ss := csmock.NewMockServerService(ctrl)
+			vs := csmock.NewMockVolumeService(ctrl)
+			actuator := newActuator(c, ss, nil, vs)
+			actuator.quota = tc.quota
+
+			ss.EXPECT().List(gomock.Any()).Return(servers, nil)
+			vs.EXPECT().List(gomock.Any()).Return(volumes, nil)
+			if tc.created != "" {
+				ss.EXPECT().Create(gomock.Any(), gomock.Cond(func(req *cloudscale.ServerRequest) bool {
+					return req.Flavor == tc.created
+				})).DoAndReturn(cloudscaleServerFromServerRequest(func(*cloudscale.Server) {}))
+			}
+
+			err := actuator.Create(t.Context(), machine)
+			if tc.created != "" {
+				require.NoError(t, err)
+			} else {
+				var requeueErr *machinecontroller.RequeueAfterError
+				require.True(t, errors.As(err, &requeueErr), "exceeding the quota should requeue, got %v", err)
+				assert.Equal(t, quotaExceededMinRequeueAfter, requeueErr.RequeueAfter)
+				assert.ErrorContains(t, err, tc.expectedMessage)
+				assert.ErrorIs(t, err, errQuotaExceeded)
+				assert.Empty(t, drainEvents(actuator.eventRecorder.(*record.FakeRecorder)), "exceeding the quota is an expected requeue")
+			}
+
+			var updated machinev1beta1.Machine
+			require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(machine), &updated))
+			status, err := csv1beta1.ProviderStatusFromRawExtension(updated.Status.ProviderStatus)
+			require.NoError(t, err)
+			cond := meta.FindStatusCondition(status.Conditions, csv1beta1.QuotaExceededCondition)
+			require.NotNil(t, cond)
+			assert.Equal(t, tc.expectedStatus, cond.Status)
+			assert.Equal(t, tc.expectedMessage, cond.Message)
+		})
+	}
+}
+
+func Test_Actuator_Create_QuotaBackoff(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app-test",
+			Labels: map[string]string{
+				machineClusterIDLabelName: "cluster-id",
+			},
+		},
+	}
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{
+		Zone:   "rma1",
+		Flavor: "plus-16-4",
+		// The quota is checked before the server group is ensured, the actuator has no server group client.
+		AntiAffinityKey: "app",
+	})
+	require.NoError(t, setProviderStatusCondition(machine, metav1.Condition{
+		Type:               csv1beta1.QuotaExceededCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             conditionReasonQuotaExceeded,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-3 * time.Minute)),
+	}))
+
+	c := newFakeClient(t, machine)
+	ss := csmock.NewMockServerService(ctrl)
+	vs := csmock.NewMockVolumeService(ctrl)
+	actuator := newActuator(c, ss, nil, vs)
+	actuator.quota = Quota{VCPUs: 2, CacheDuration: time.Minute}
+
+	// The usage is only fetched once within the cache duration
+	ss.EXPECT().List(gomock.Any()).Return(nil, nil).Times(1)
+	vs.EXPECT().List(gomock.Any()).Return(nil, nil).Times(1)
+
+	for range 2 {
+		err := actuator.Create(t.Context(), machine)
+		var requeueErr *machinecontroller.RequeueAfterError
+		require.True(t, errors.As(err, &requeueErr), "exceeding the quota should requeue, got %v", err)
+		assert.InDelta(t, 3*time.Minute, requeueErr.RequeueAfter, float64(time.Second), "the requeue delay should grow with the time the quota is exceeded")
+		assert.ErrorContains(t, err, "2 vCPUs missing (4 requested, 0 of 2 used)")
+	}
+}
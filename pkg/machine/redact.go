@@ -0,0 +1,158 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+)
+
+const redactedPlaceholder = "[REDACTED]"
+
+// redactedJSON marshals a cloudscale API request for use in logs and error messages.
+// Sensitive fields such as user data, SSH keys, and passwords are replaced with a placeholder.
+// All requests that are logged or embedded in errors must go through this function.
+func redactedJSON(req any) string {
+	switch r := req.(type) {
+	case *cloudscale.ServerRequest:
+		req = redactServerRequest(r)
+	case cloudscale.ServerRequest:
+		req = redactServerRequest(&r)
+	}
+
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Sprintf("<failed to marshal request: %v>", err)
+	}
+	return string(raw)
+}
+
+// redactServerRequest returns a shallow copy of the request with user data, SSH keys, and password replaced.
+func redactServerRequest(req *cloudscale.ServerRequest) *cloudscale.ServerRequest {
+	if req == nil {
+		return nil
+	}
+
+	r := *req
+	if r.UserData != "" {
+		r.UserData = redactedPlaceholder
+	}
+	if r.Password != "" {
+		r.Password = redactedPlaceholder
+	}
+	if len(r.SSHKeys) > 0 {
+		keys := make([]string, len(r.SSHKeys))
+		for i := range keys {
+			keys[i] = redactedPlaceholder
+		}
+		r.SSHKeys = keys
+	}
+	return &r
+}
+
+// redactError removes the token, SSH keys, and rendered user data of the machine from the error message.
+func (mctx *machineContext) redactError(err error) error {
+	return redactError(err, mctx.sensitiveValues...)
+}
+
+// minRedactedFragmentLength is the minimum length of a line of a multi-line sensitive value to be redacted on its own.
+// Jsonnet errors quote single lines of the template and of the values it evaluates.
+const minRedactedFragmentLength = 8
+
+// redactError returns an error with all occurrences of the given sensitive values removed from its message.
+// Lines of multi-line sensitive values are redacted on their own as well.
+// The original error is not exposed. errors.Is matches the original error, and errors.As returns redacted copies of
+// the error types the actuator and the machine controller inspect.
+// The cloudscale API might echo invalid values, such as malformed SSH keys, back in its error responses.
+func redactError(err error, sensitive ...string) error {
+	if err == nil {
+		return nil
+	}
+
+	fragments := sensitiveFragments(sensitive)
+	msg := err.Error()
+	redacted := redactString(msg, fragments)
+	if redacted == msg {
+		return err
+	}
+	return &redactedError{err: err, msg: redacted, fragments: fragments}
+}
+
+// sensitiveFragments returns the sensitive values and the lines of multi-line values, longest first.
+func sensitiveFragments(sensitive []string) []string {
+	fragments := make([]string, 0, len(sensitive))
+	for _, s := range sensitive {
+		if s == "" {
+			continue
+		}
+		fragments = append(fragments, s)
+		if !strings.Contains(s, "\n") {
+			continue
+		}
+		for _, line := range strings.Split(s, "\n") {
+			if line = strings.TrimSpace(line); len(line) >= minRedactedFragmentLength {
+				fragments = append(fragments, line)
+			}
+		}
+	}
+	slices.SortStableFunc(fragments, func(a, b string) int { return len(b) - len(a) })
+	return fragments
+}
+
+func redactString(s string, fragments []string) string {
+	for _, f := range fragments {
+		s = strings.ReplaceAll(s, f, redactedPlaceholder)
+	}
+	return s
+}
+
+type redactedError struct {
+	err       error
+	msg       string
+	fragments []string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// Is reports whether the original error matches the target, without exposing the original error.
+func (e *redactedError) Is(target error) bool {
+	return errors.Is(e.err, target)
+}
+
+// As sets the target to a redacted copy of the first error in the chain of the original error matching its type.
+// Only the error types inspected by the actuator and the machine controller are supported.
+func (e *redactedError) As(target any) bool {
+	switch t := target.(type) {
+	case **machinecontroller.MachineError:
+		var merr *machinecontroller.MachineError
+		if !errors.As(e.err, &merr) {
+			return false
+		}
+		*t = &machinecontroller.MachineError{Reason: merr.Reason, Message: redactString(merr.Message, e.fragments)}
+	case **machinecontroller.RequeueAfterError:
+		var rerr *machinecontroller.RequeueAfterError
+		if !errors.As(e.err, &rerr) {
+			return false
+		}
+		*t = &machinecontroller.RequeueAfterError{RequeueAfter: rerr.RequeueAfter}
+	case **cloudscale.ErrorResponse:
+		var errResp *cloudscale.ErrorResponse
+		if !errors.As(e.err, &errResp) {
+			return false
+		}
+		msg := make(map[string]string, len(errResp.Message))
+		for k, v := range errResp.Message {
+			msg[k] = redactString(v, e.fragments)
+		}
+		*t = &cloudscale.ErrorResponse{StatusCode: errResp.StatusCode, Message: msg}
+	default:
+		return false
+	}
+	return true
+}
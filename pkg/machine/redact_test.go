@@ -0,0 +1,236 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine/csmock"
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v6"
+	"github.com/go-logr/logr/funcr"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+func Test_Actuator_RedactsSensitiveValues(t *testing.T) {
+	const (
+		clusterID = "cluster-id"
+
+		secretToken    = "SECRET-TOKEN-MARKER"
+		secretUserData = `{"secret":"SECRET-USERDATA-MARKER"}`
+		secretSSHKey   = "ssh-ed25519 SECRET-SSHKEY-MARKER"
+	)
+	sensitive := []string{secretToken, secretUserData, "SECRET-USERDATA-MARKER", secretSSHKey}
+	// echoError simulates a cloudscale API error echoing back the given values sent with the request.
+	echoError := func(values ...string) error {
+		return &cloudscale.ErrorResponse{
+			StatusCode: 400,
+			Message: map[string]string{
+				"detail": "invalid request: " + strings.Join(values, ", "),
+			},
+		}
+	}
+
+	serverWithRootVolume := []cloudscale.Server{{
+		UUID: "server-uuid",
+		TaggedResource: cloudscale.TaggedResource{
+			Tags: cloudscale.TagMap{
+				machineNameTag:      "app-test",
+				machineClusterIDTag: clusterID,
+			},
+		},
+		Volumes: []cloudscale.VolumeStub{{UUID: "root-volume-uuid"}},
+	}}
+
+	tcs := []struct {
+		name    string
+		action  func(*Actuator, context.Context, *machinev1beta1.Machine) error
+		apiMock func(*csmock.MockServerService, *csmock.MockServerGroupService, *csmock.MockVolumeService)
+	}{
+		{
+			name:   "create server fails",
+			action: (*Actuator).Create,
+			apiMock: func(ss *csmock.MockServerService, sgs *csmock.MockServerGroupService, vs *csmock.MockVolumeService) {
+				sgs.EXPECT().List(gomock.Any(), gomock.Any()).Return([]cloudscale.ServerGroup{}, nil)
+				sgs.EXPECT().Create(gomock.Any(), gomock.Any()).Return(&cloudscale.ServerGroup{UUID: "sg-uuid"}, nil)
+				ss.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil, echoError(secretToken, secretUserData, secretSSHKey))
+			},
+		},
+		{
+			name:   "list server groups fails",
+			action: (*Actuator).Create,
+			apiMock: func(ss *csmock.MockServerService, sgs *csmock.MockServerGroupService, vs *csmock.MockVolumeService) {
+				sgs.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, echoError(secretToken))
+			},
+		},
+		{
+			name:   "tag root volume fails",
+			action: (*Actuator).Create,
+			apiMock: func(ss *csmock.MockServerService, sgs *csmock.MockServerGroupService, vs *csmock.MockVolumeService) {
+				sgs.EXPECT().List(gomock.Any(), gomock.Any()).Return([]cloudscale.ServerGroup{}, nil)
+				sgs.EXPECT().Create(gomock.Any(), gomock.Any()).Return(&cloudscale.ServerGroup{UUID: "sg-uuid"}, nil)
+				ss.EXPECT().Create(gomock.Any(), gomock.Any()).Return(&serverWithRootVolume[0], nil)
+				ss.EXPECT().Get(gomock.Any(), "server-uuid").Return(&serverWithRootVolume[0], nil)
+				vs.EXPECT().Get(gomock.Any(), "root-volume-uuid").Return(&cloudscale.Volume{}, nil)
+				vs.EXPECT().Update(gomock.Any(), "root-volume-uuid", gomock.Any()).Return(echoError(secretToken, secretUserData, secretSSHKey))
+			},
+		},
+		{
+			name:   "update server tags fails",
+			action: (*Actuator).Update,
+			apiMock: func(ss *csmock.MockServerService, sgs *csmock.MockServerGroupService, vs *csmock.MockVolumeService) {
+				ss.EXPECT().List(gomock.Any(), gomock.Any()).Return(serverWithRootVolume, nil)
+				ss.EXPECT().Update(gomock.Any(), "server-uuid", gomock.Any()).Return(echoError(secretToken, secretSSHKey))
+			},
+		},
+		{
+			name:   "delete server fails",
+			action: (*Actuator).Delete,
+			apiMock: func(ss *csmock.MockServerService, sgs *csmock.MockServerGroupService, vs *csmock.MockVolumeService) {
+				ss.EXPECT().List(gomock.Any(), gomock.Any()).Return(serverWithRootVolume, nil)
+				ss.EXPECT().Delete(gomock.Any(), "server-uuid").Return(echoError(secretToken, secretSSHKey))
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var logs strings.Builder
+			ctx := log.IntoContext(t.Context(), funcr.New(func(prefix, args string) {
+				logs.WriteString(prefix + " " + args + "\n")
+			}, funcr.Options{Verbosity: 10}))
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "app-test",
+					Labels: map[string]string{
+						machineClusterIDLabelName: clusterID,
+					},
+				},
+			}
+			providerSpec := csv1beta1.CloudscaleMachineProviderSpec{
+				UserDataSecret:        &corev1.LocalObjectReference{Name: "app-user-data"},
+				StoreRenderedUserData: true,
+				TokenSecret:           &corev1.LocalObjectReference{Name: "cloudscale-token"},
+				Zone:                  "rma1",
+				AntiAffinityKey:       "app",
+				SSHKeys:               []string{secretSSHKey},
+				RootVolumeTags:        map[string]string{"volume-purpose": "root"},
+				Tags:                  map[string]string{"new-tag": "new-value"},
+			}
+			setProviderSpecOnMachine(t, machine, &providerSpec)
+			tokenSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: providerSpec.TokenSecret.Name,
+				},
+				Data: map[string][]byte{
+					"token": []byte(secretToken),
+				},
+			}
+			userDataSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: providerSpec.UserDataSecret.Name,
+				},
+				Data: map[string][]byte{
+					"userData": []byte(secretUserData),
+				},
+			}
+
+			c := newFakeClient(t, machine, tokenSecret, userDataSecret)
+			ss := csmock.NewMockServerService(ctrl)
+			sgs := csmock.NewMockServerGroupService(ctrl)
+			vs := csmock.NewMockVolumeService(ctrl)
+			actuator := newActuator(c, ss, sgs, vs)
+			recorder := record.NewFakeRecorder(10)
+			actuator.eventRecorder = recorder
+
+			tc.apiMock(ss, sgs, vs)
+
+			err := tc.action(actuator, ctx, machine)
+			require.Error(t, err)
+			var errResp *cloudscale.ErrorResponse
+			if assert.True(t, errors.As(err, &errResp), "API error should be accessible") {
+				for _, s := range sensitive {
+					assert.NotContains(t, errResp.Error(), s, "API error must not contain sensitive values")
+				}
+			}
+			for inner := errors.Unwrap(err); inner != nil; inner = errors.Unwrap(inner) {
+				for _, s := range sensitive {
+					assert.NotContains(t, inner.Error(), s, "unwrapped errors must not contain sensitive values")
+				}
+			}
+
+			events := strings.Join(drainEvents(recorder), "\n")
+			for _, s := range sensitive {
+				assert.NotContains(t, err.Error(), s, "error message must not contain sensitive values")
+				assert.NotContains(t, events, s, "events must not contain sensitive values")
+				assert.NotContains(t, logs.String(), s, "logs must not contain sensitive values")
+			}
+		})
+	}
+}
+
+func Test_redactedJSON(t *testing.T) {
+	req := &cloudscale.ServerRequest{
+		Name:     "app-test",
+		SSHKeys:  []string{"ssh-ed25519 AAAA", "ssh-rsa BBBB"},
+		Password: "hunter2",
+		UserData: `{"ignition":{}}`,
+	}
+
+	assert.JSONEq(t,
+		`{"name":"app-test","flavor":"","image":"","ssh_keys":["[REDACTED]","[REDACTED]"],"password":"[REDACTED]","user_data":"[REDACTED]"}`,
+		redactedJSON(req),
+	)
+	assert.Equal(t, `{"ignition":{}}`, req.UserData, "original request must not be modified")
+	assert.Equal(t, "ssh-ed25519 AAAA", req.SSHKeys[0], "original request must not be modified")
+}
+
+func Test_redactError(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	userData := "{\n  \"password\": \"SECRET-PASSWORD\",\n  \"user\": \"core\"\n}"
+
+	err := redactError(
+		fmt.Errorf("wrapped: %w", errors.Join(
+			sentinel,
+			machinecontroller.InvalidMachineConfiguration("invalid token SECRET-TOKEN"),
+			&machinecontroller.RequeueAfterError{RequeueAfter: time.Minute},
+			fmt.Errorf(`RUNTIME ERROR: context:2:3-31 "password": "SECRET-PASSWORD",`),
+		)),
+		"SECRET-TOKEN", userData,
+	)
+
+	assert.NotContains(t, err.Error(), "SECRET-TOKEN")
+	assert.NotContains(t, err.Error(), "SECRET-PASSWORD", "lines of multi-line values should be redacted")
+	assert.Nil(t, errors.Unwrap(err), "the original error must not be exposed")
+	assert.ErrorIs(t, err, sentinel)
+
+	var merr *machinecontroller.MachineError
+	if assert.True(t, errors.As(err, &merr)) {
+		assert.Equal(t, machinev1beta1.InvalidConfigurationMachineError, merr.Reason)
+		assert.Equal(t, "invalid token [REDACTED]", merr.Message)
+	}
+	var rerr *machinecontroller.RequeueAfterError
+	if assert.True(t, errors.As(err, &rerr)) {
+		assert.Equal(t, time.Minute, rerr.RequeueAfter)
+	}
+	var errResp *cloudscale.ErrorResponse
+	assert.False(t, errors.As(err, &errResp))
+}
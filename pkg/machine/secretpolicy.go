@@ -0,0 +1,97 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// Condition reasons for the UserDataSecretsAllowed condition.
+const (
+	conditionReasonUserDataSecretsAllowed = "AllSecretsAllowed"
+	conditionReasonUserDataSecretsBlocked = "SecretsBlocked"
+)
+
+// UserDataSecretPolicy restricts the secrets UserDataSecretSelector and the Jsonnet library can pass to the user data Jsonnet template.
+// The zero value allows all secrets.
+type UserDataSecretPolicy struct {
+	// AllowedLabelPrefix is a label key or label key prefix a secret must carry to be passed to the template.
+	// If empty, no label is required.
+	AllowedLabelPrefix string
+	// DeniedTypes are secret types that are never passed to the template.
+	DeniedTypes []corev1.SecretType
+}
+
+// filter splits the secrets into the secrets allowed by the policy and the names of the blocked secrets.
+// The reasons are keyed by the names of the blocked secrets.
+func (p UserDataSecretPolicy) filter(secrets []corev1.Secret) (allowed []corev1.Secret, blocked []string, reasons map[string]string) {
+	allowed = make([]corev1.Secret, 0, len(secrets))
+	reasons = map[string]string{}
+	for _, s := range secrets {
+		if reason, ok := p.allows(s); !ok {
+			blocked = append(blocked, s.Name)
+			reasons[s.Name] = reason
+			continue
+		}
+		allowed = append(allowed, s)
+	}
+	return allowed, blocked, reasons
+}
+
+// allows returns whether the policy allows the secret and the reason if it does not.
+func (p UserDataSecretPolicy) allows(s corev1.Secret) (string, bool) {
+	if slices.Contains(p.DeniedTypes, s.Type) {
+		return fmt.Sprintf("secret type %q is denied", s.Type), false
+	}
+	if p.AllowedLabelPrefix == "" {
+		return "", true
+	}
+	for k := range s.Labels {
+		if strings.HasPrefix(k, p.AllowedLabelPrefix) {
+			return "", true
+		}
+	}
+	return fmt.Sprintf("secret has no label with key prefix %q", p.AllowedLabelPrefix), false
+}
+
+// userDataSecretsAllowedCondition returns the UserDataSecretsAllowed condition for the given blocked secrets.
+func userDataSecretsAllowedCondition(machine *machinev1beta1.Machine, blocked []string) metav1.Condition {
+	cond := metav1.Condition{
+		Type:               csv1beta1.UserDataSecretsAllowedCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             conditionReasonUserDataSecretsAllowed,
+		Message:            "All secrets matching UserDataSecretSelector and all Jsonnet library secrets are allowed by the policy",
+		ObservedGeneration: machine.Generation,
+	}
+	if len(blocked) > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = conditionReasonUserDataSecretsBlocked
+		cond.Message = fmt.Sprintf("Secrets blocked by the policy and not passed to the user data: %s", strings.Join(blocked, ", "))
+	}
+	return cond
+}
+
+// reportBlockedUserDataSecrets sets the UserDataSecretsAllowed condition if the policy was applied while loading the user data.
+// If rendering failed, the machine is patched right away, the blocked secrets might be the reason.
+func (a *Actuator) reportBlockedUserDataSecrets(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext, renderFailed bool) error {
+	if !mctx.userDataSecretPolicyApplied {
+		return nil
+	}
+	if err := setProviderStatusCondition(machine, userDataSecretsAllowedCondition(machine, mctx.blockedUserDataSecrets)); err != nil {
+		return fmt.Errorf("failed to set user data secrets condition on machine %q: %w", machine.Name, err)
+	}
+	if !renderFailed {
+		return nil
+	}
+	if err := a.patchMachine(ctx, mctx.machine, machine); err != nil {
+		return fmt.Errorf("failed to patch machine %q: %w", machine.Name, err)
+	}
+	return nil
+}
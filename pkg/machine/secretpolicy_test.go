@@ -0,0 +1,194 @@
+package machine
+
+import (
+	"context"
+	"testing"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+func Test_UserDataSecretPolicy_filter(t *testing.T) {
+	t.Parallel()
+
+	secrets := []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "labeled", Labels: map[string]string{"userdata.appuio.io/ca": "true"}},
+			Type:       corev1.SecretTypeOpaque,
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"},
+			Type:       corev1.SecretTypeOpaque,
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "sa-token", Labels: map[string]string{"userdata.appuio.io/token": "true"}},
+			Type:       corev1.SecretTypeServiceAccountToken,
+		},
+	}
+
+	tcs := []struct {
+		name            string
+		policy          UserDataSecretPolicy
+		expectedAllowed []string
+		expectedBlocked []string
+	}{
+		{
+			name:            "zero value allows all",
+			expectedAllowed: []string{"labeled", "unlabeled", "sa-token"},
+		},
+		{
+			name:            "label prefix",
+			policy:          UserDataSecretPolicy{AllowedLabelPrefix: "userdata.appuio.io/"},
+			expectedAllowed: []string{"labeled", "sa-token"},
+			expectedBlocked: []string{"unlabeled"},
+		},
+		{
+			name:            "label key",
+			policy:          UserDataSecretPolicy{AllowedLabelPrefix: "userdata.appuio.io/ca"},
+			expectedAllowed: []string{"labeled"},
+			expectedBlocked: []string{"unlabeled", "sa-token"},
+		},
+		{
+			name: "denied types",
+			policy: UserDataSecretPolicy{
+				AllowedLabelPrefix: "userdata.appuio.io/",
+				DeniedTypes:        []corev1.SecretType{corev1.SecretTypeServiceAccountToken, corev1.SecretTypeDockerConfigJson},
+			},
+			expectedAllowed: []string{"labeled"},
+			expectedBlocked: []string{"unlabeled", "sa-token"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			allowed, blocked, reasons := tc.policy.filter(secrets)
+			allowedNames := make([]string, 0, len(allowed))
+			for _, s := range allowed {
+				allowedNames = append(allowedNames, s.Name)
+			}
+			assert.Equal(t, tc.expectedAllowed, allowedNames)
+			assert.Equal(t, tc.expectedBlocked, blocked)
+			assert.Len(t, reasons, len(tc.expectedBlocked))
+		})
+	}
+}
+
+func Test_Actuator_loadAndRenderUserDataSecret_UserDataSecretPolicy(t *testing.T) {
+	t.Parallel()
+
+	selectorLabels := map[string]string{"app": "userdata"}
+	objs := []runtime.Object{
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+			Data: map[string][]byte{
+				"userData": []byte(`[s.metadata.name for s in std.extVar('context').secrets] + [import 'lib/allowed.libsonnet']`),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "allowed", Namespace: "default", Labels: map[string]string{"app": "userdata", "userdata.appuio.io/allowed": "true"}},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default", Labels: selectorLabels},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "default", Labels: map[string]string{"app": "userdata", "userdata.appuio.io/allowed": "true"}},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{}`)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "allowed-lib", Namespace: "default", Labels: map[string]string{csv1beta1.JsonnetLibraryLabel: "true", "userdata.appuio.io/allowed": "true"}},
+			Data:       map[string][]byte{"allowed.libsonnet": []byte(`'allowed-lib'`)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "blocked-lib", Namespace: "default", Labels: map[string]string{csv1beta1.JsonnetLibraryLabel: "true"}},
+			Data:       map[string][]byte{"blocked.libsonnet": []byte(`'blocked-lib'`)},
+		},
+	}
+	actuator := NewActuator(ActuatorParams{
+		K8sClient: newFakeClient(t, objs...),
+		UserDataSecretPolicy: UserDataSecretPolicy{
+			AllowedLabelPrefix: "userdata.appuio.io/",
+			DeniedTypes:        []corev1.SecretType{corev1.SecretTypeDockerConfigJson},
+		},
+	})
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+	}
+	mctx := &machineContext{
+		machine: machine,
+		spec: csv1beta1.CloudscaleMachineProviderSpec{
+			UserDataSecret:         &corev1.LocalObjectReference{Name: "app-user-data"},
+			UserDataSecretSelector: &metav1.LabelSelector{MatchLabels: selectorLabels},
+		},
+	}
+
+	ud, err := actuator.loadAndRenderUserDataSecret(context.Background(), mctx)
+	require.NoError(t, err)
+	assert.Equal(t, `["allowed","allowed-lib"]`, ud)
+	assert.ElementsMatch(t, []string{"credentials", "pull-secret", "blocked-lib (jsonnet library)"}, mctx.blockedUserDataSecrets)
+
+	cond := userDataSecretsAllowedCondition(machine, mctx.blockedUserDataSecrets)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, conditionReasonUserDataSecretsBlocked, cond.Reason)
+	assert.Contains(t, cond.Message, "credentials")
+	assert.Contains(t, cond.Message, "pull-secret")
+	assert.Contains(t, cond.Message, "blocked-lib")
+
+	assert.Equal(t, metav1.ConditionTrue, userDataSecretsAllowedCondition(machine, nil).Status)
+}
+
+func Test_Actuator_Create_UserDataSecretPolicy_RenderError(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-test",
+			Namespace: "default",
+			Labels: map[string]string{
+				machineClusterIDLabelName: "cluster-id",
+			},
+		},
+	}
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{
+		Zone:           "rma1",
+		UserDataSecret: &corev1.LocalObjectReference{Name: "app-user-data"},
+	})
+	c := newFakeClient(t,
+		machine,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+			Data: map[string][]byte{
+				"userData": []byte(`import 'lib/blocked.libsonnet'`),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "blocked-lib", Namespace: "default", Labels: map[string]string{csv1beta1.JsonnetLibraryLabel: "true"}},
+			Data:       map[string][]byte{"blocked.libsonnet": []byte(`{}`)},
+		},
+	)
+	actuator := newActuator(c, nil, nil, nil)
+	actuator.userDataSecretPolicy = UserDataSecretPolicy{AllowedLabelPrefix: "userdata.appuio.io/"}
+
+	require.ErrorContains(t, actuator.Create(ctx, machine), `import "lib/blocked.libsonnet" not found`)
+
+	updated := &machinev1beta1.Machine{}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(machine), updated))
+	status, err := csv1beta1.ProviderStatusFromRawExtension(updated.Status.ProviderStatus)
+	require.NoError(t, err)
+	cond := meta.FindStatusCondition(status.Conditions, csv1beta1.UserDataSecretsAllowedCondition)
+	require.NotNil(t, cond, "the condition should be patched if rendering fails")
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Contains(t, cond.Message, "blocked-lib (jsonnet library)")
+}
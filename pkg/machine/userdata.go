@@ -0,0 +1,594 @@
+package machine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blang/semver/v4"
+	"github.com/google/go-jsonnet"
+	configv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// DefaultMaxUserDataSize is the default maximum size in bytes of the user data sent to the cloudscale API.
+const DefaultMaxUserDataSize = 64 * 1024
+
+// jsonnetLibraryImportPrefix is the prefix under which the files of the Jsonnet library are importable.
+const jsonnetLibraryImportPrefix = "lib/"
+
+// renderedUserDataSecretSuffix is appended to the machine name to get the name of the secret storing the rendered user data.
+const renderedUserDataSecretSuffix = "-rendered-user-data"
+
+// cloudConfigHeader marks user data as cloud-config for cloud-init.
+const cloudConfigHeader = "#cloud-config\n"
+
+// userDataKey is the key of the Jsonnet template in the UserDataSecret and of the user data in the rendered user data secret.
+const userDataKey = "userData"
+
+func (a *Actuator) loadAndRenderUserDataSecret(ctx context.Context, mctx *machineContext) (string, error) {
+	mctx.userDataSourcesVersion = ""
+	if mctx.spec.UserDataSecret == nil {
+		return "", nil
+	}
+
+	secret, err := a.getUserDataSecret(ctx, mctx)
+	if err != nil {
+		return "", err
+	}
+	userData := string(secret.Data[userDataKey])
+	mctx.userDataSecretResourceVersion = secret.ResourceVersion
+	mctx.userDataSecretPolicyApplied = false
+	mctx.blockedUserDataSecrets = nil
+
+	if userData == "" {
+		mctx.userDataSourcesVersion = userDataSourcesVersion(secret, nil, nil, nil)
+		return "", nil
+	}
+
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	// Jsonnet errors might quote the template and the values of the secrets
+	mctx.addSensitiveSecretData(secret)
+
+	selected, err := a.listUserDataSecrets(ctx, mctx)
+	if err != nil {
+		return "", err
+	}
+	var userDataSecrets corev1.SecretList
+	if mctx.spec.UserDataSecretSelector != nil {
+		allowed, blocked, reasons := a.userDataSecretPolicy.filter(selected)
+		if len(blocked) > 0 {
+			log.FromContext(ctx).WithName("Actuator.loadAndRenderUserDataSecret").Info("Secrets matching UserDataSecretSelector blocked by policy", "machine", mctx.machine.Name, "blocked", reasons)
+		}
+		userDataSecrets.Items = allowed
+		mctx.userDataSecretPolicyApplied = true
+		mctx.blockedUserDataSecrets = blocked
+		for i := range allowed {
+			mctx.addSensitiveSecretData(&allowed[i])
+		}
+	}
+
+	libConfigMaps, libSecrets, err := a.listJsonnetLibrary(ctx, mctx.machine.Namespace, userData)
+	if err != nil {
+		return "", fmt.Errorf("userData: %w", err)
+	}
+	mctx.userDataSourcesVersion = userDataSourcesVersion(secret, selected, libConfigMaps, libSecrets)
+	lib := &libraryImporter{}
+	if jsonnetImport.MatchString(userData) {
+		lib, err = a.loadJsonnetLibrary(ctx, mctx, libConfigMaps, libSecrets)
+		if err != nil {
+			return "", fmt.Errorf("userData: %w", err)
+		}
+	}
+
+	jvm, err := a.jsonnetVMWithContext(ctx, mctx, data, userDataSecrets, lib)
+	if err != nil {
+		return "", fmt.Errorf("userData: failed to create jsonnet VM: %w", err)
+	}
+	format := mctx.spec.UserDataFormat
+	if format == "" {
+		format = csv1beta1.UserDataFormatJSON
+	}
+	jvm.StringOutput = format == csv1beta1.UserDataFormatRaw
+
+	// The machine's resource version changes on every status update, so the machine's UID identifies the context instead.
+	ud, err := a.jsonnetLimits.EvaluateAnonymousSnippet(ctx, jvm, "context", userData, string(mctx.machine.UID)+mctx.userDataSourcesVersion)
+	if err != nil {
+		return "", fmt.Errorf("userData: failed to evaluate jsonnet: %w", err)
+	}
+
+	ud, err = formatUserData(format, ud)
+	if err != nil {
+		return "", err
+	}
+
+	return a.fitUserData(ud, format, mctx.spec.CompressUserData)
+}
+
+// fitUserData ensures the user data does not exceed the maximum user data size.
+// If compression is allowed, ignition user data exceeding the limit is gzipped and wrapped in a minimal ignition config.
+// Returns a terminal error if the user data does not fit.
+func (a *Actuator) fitUserData(userData string, format csv1beta1.UserDataFormat, compress bool) (string, error) {
+	if len(userData) <= a.maxUserDataSize {
+		return userData, nil
+	}
+	if !compress {
+		return "", machinecontroller.InvalidMachineConfiguration(
+			"rendered user data is %d bytes, exceeds the maximum of %d bytes; consider setting compressUserData", len(userData), a.maxUserDataSize)
+	}
+	if format != csv1beta1.UserDataFormatJSON {
+		return "", machinecontroller.InvalidMachineConfiguration(
+			"rendered user data is %d bytes, exceeds the maximum of %d bytes; compression is only supported for the %q user data format", len(userData), a.maxUserDataSize, csv1beta1.UserDataFormatJSON)
+	}
+
+	version, err := compressedIgnitionVersion(userData)
+	if err != nil {
+		return "", err
+	}
+	compressed, err := compressedIgnitionConfig(userData, version)
+	if err != nil {
+		return "", fmt.Errorf("userData: failed to compress: %w", err)
+	}
+	if len(compressed) > a.maxUserDataSize {
+		return "", machinecontroller.InvalidMachineConfiguration(
+			"rendered user data is %d bytes and %d bytes compressed, exceeds the maximum of %d bytes", len(userData), len(compressed), a.maxUserDataSize)
+	}
+	return compressed, nil
+}
+
+// minCompressedIgnitionVersion is the first ignition spec version supporting compression of merged configs.
+var minCompressedIgnitionVersion = semver.MustParse("3.1.0")
+
+// compressedIgnitionVersion returns the ignition spec version of the wrapper of the given ignition config.
+// The wrapper uses the version of the config, but at least minCompressedIgnitionVersion.
+// Returns a terminal error if the config is not an ignition spec 3 config, older specs can't be merged into the wrapper.
+func compressedIgnitionVersion(config string) (string, error) {
+	var c struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal([]byte(config), &c); err != nil {
+		return "", fmt.Errorf("userData: failed to parse ignition config: %w", err)
+	}
+	v, err := semver.Parse(c.Ignition.Version)
+	if err != nil {
+		return "", machinecontroller.InvalidMachineConfiguration("compression is only supported for ignition configs, failed to parse ignition version %q: %v", c.Ignition.Version, err)
+	}
+	if v.Major != minCompressedIgnitionVersion.Major {
+		return "", machinecontroller.InvalidMachineConfiguration("compression is only supported for ignition spec 3 configs, got version %q", c.Ignition.Version)
+	}
+	if v.LT(minCompressedIgnitionVersion) {
+		return minCompressedIgnitionVersion.String(), nil
+	}
+	return v.String(), nil
+}
+
+// compressedIgnitionConfig returns a minimal ignition config with the given spec version merging the gzipped ignition config from a base64 data URL.
+func compressedIgnitionConfig(config, version string) (string, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := zw.Write([]byte(config)); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	wrapper, err := json.Marshal(map[string]any{
+		"ignition": map[string]any{
+			"version": version,
+			"config": map[string]any{
+				"merge": []any{
+					map[string]any{
+						"compression": "gzip",
+						"source":      "data:;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(wrapper), nil
+}
+
+// formatUserData converts the output of the Jsonnet evaluation to the given format.
+func formatUserData(format csv1beta1.UserDataFormat, output string) (string, error) {
+	switch format {
+	case csv1beta1.UserDataFormatJSON:
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, []byte(output)); err != nil {
+			return "", fmt.Errorf("userData: failed to compact json: %w", err)
+		}
+		return compacted.String(), nil
+	case csv1beta1.UserDataFormatYAML, csv1beta1.UserDataFormatCloudInit:
+		y, err := yaml.JSONToYAML([]byte(output))
+		if err != nil {
+			return "", fmt.Errorf("userData: failed to convert json to yaml: %w", err)
+		}
+		if format == csv1beta1.UserDataFormatCloudInit {
+			return cloudConfigHeader + string(y), nil
+		}
+		return string(y), nil
+	case csv1beta1.UserDataFormatRaw:
+		// Jsonnet terminates the string output with a newline
+		return strings.TrimSuffix(output, "\n"), nil
+	default:
+		return "", fmt.Errorf("userData: unknown user data format %q", format)
+	}
+}
+
+// jsonnetVMWithContext returns a Jsonnet VM with the user data context available as std.extVar('context').
+// The machineSet and infrastructure keys are only fetched from the API if the template accesses them.
+func (a *Actuator) jsonnetVMWithContext(ctx context.Context, mctx *machineContext, data map[string]string, userDataSecrets corev1.SecretList, importer jsonnet.Importer) (*jsonnet.VM, error) {
+	jcr, err := json.Marshal(map[string]any{
+		"machine":         userDataMachine(mctx.machine),
+		"data":            data,
+		"secrets":         userDataSecrets.Items,
+		"zone":            mctx.spec.Zone,
+		"region":          regionFromZone(mctx.spec.Zone),
+		"serverGroups":    mctx.serverGroups,
+		"providerVersion": a.providerVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal jsonnet context: %w", err)
+	}
+	jvm := jsonnet.MakeVM()
+	// Object fields are evaluated lazily, the native functions are only called if the field is accessed.
+	jvm.ExtCode("context", string(jcr)+` + {
+  machineSet: std.native('machineSet')(),
+  infrastructure: std.native('infrastructure')(),
+}`)
+	jvm.NativeFunction(lazyNativeFunction("machineSet", func() (client.Object, error) {
+		return a.owningMachineSet(ctx, mctx.machine)
+	}))
+	jvm.NativeFunction(lazyNativeFunction("infrastructure", func() (client.Object, error) {
+		return a.clusterInfrastructure(ctx)
+	}))
+	jvm.Importer(importer)
+	return jvm, nil
+}
+
+// userDataMachine returns a copy of the machine as it is seen by the user data template.
+// The fields the provider and the API server set after the server is created are removed,
+// so the user data rendered for the drift check matches the user data rendered during create.
+func userDataMachine(machine *machinev1beta1.Machine) *machinev1beta1.Machine {
+	m := machine.DeepCopy()
+	m.ResourceVersion = ""
+	m.Generation = 0
+	m.ManagedFields = nil
+	for _, l := range []string{machinecontroller.MachineInstanceTypeLabelName, machinecontroller.MachineRegionLabelName, machinecontroller.MachineAZLabelName} {
+		delete(m.Labels, l)
+	}
+	delete(m.Annotations, machinecontroller.MachineInstanceStateAnnotationName)
+	delete(m.Annotations, csv1beta1.AllocatedAddressesAnnotation)
+	m.Spec.ProviderID = nil
+	m.Status = machinev1beta1.MachineStatus{}
+	return m
+}
+
+// lazyNativeFunction returns a Jsonnet native function without parameters returning the object fetched by the given function.
+// The object is fetched at most once. A nil object is returned as null.
+func lazyNativeFunction(name string, fetch func() (client.Object, error)) *jsonnet.NativeFunction {
+	var (
+		once   sync.Once
+		result any
+		err    error
+	)
+	return &jsonnet.NativeFunction{
+		Name: name,
+		Func: func([]any) (any, error) {
+			once.Do(func() {
+				var obj client.Object
+				obj, err = fetch()
+				if err != nil || obj == nil || reflect.ValueOf(obj).IsNil() {
+					return
+				}
+				// Native functions must return plain JSON values
+				var raw []byte
+				raw, err = json.Marshal(obj)
+				if err != nil {
+					return
+				}
+				err = json.Unmarshal(raw, &result)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get %s: %w", name, err)
+			}
+			return result, nil
+		},
+	}
+}
+
+// owningMachineSet returns the MachineSet controlling the machine or nil if the machine is not owned by a MachineSet.
+func (a *Actuator) owningMachineSet(ctx context.Context, machine *machinev1beta1.Machine) (*machinev1beta1.MachineSet, error) {
+	owner := metav1.GetControllerOf(machine)
+	if owner == nil || owner.Kind != "MachineSet" {
+		return nil, nil
+	}
+	ms := &machinev1beta1.MachineSet{}
+	if err := a.k8sClient.Get(ctx, client.ObjectKey{Name: owner.Name, Namespace: machine.Namespace}, ms); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return ms, nil
+}
+
+// clusterInfrastructure returns the cluster Infrastructure object or nil if it does not exist, e.g. on non-OpenShift clusters.
+func (a *Actuator) clusterInfrastructure(ctx context.Context) (*configv1.Infrastructure, error) {
+	infra := &configv1.Infrastructure{}
+	if err := a.k8sClient.Get(ctx, client.ObjectKey{Name: "cluster"}, infra); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return infra, nil
+}
+
+// regionFromZone returns the cloudscale region of the zone, e.g. rma for rma1.
+func regionFromZone(zone string) string {
+	return strings.TrimRight(zone, "0123456789")
+}
+
+// jsonnetImport matches the import, importstr and importbin keywords of Jsonnet.
+// It might match inside strings or comments, which only causes the library to be loaded unnecessarily.
+var jsonnetImport = regexp.MustCompile(`\bimport(str|bin)?\b`)
+
+// getUserDataSecret returns the UserDataSecret of the machine.
+// Returns an error if the secret has no userData key.
+func (a *Actuator) getUserDataSecret(ctx context.Context, mctx *machineContext) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := a.k8sClient.Get(ctx, client.ObjectKey{Name: mctx.spec.UserDataSecret.Name, Namespace: mctx.machine.Namespace}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret %q: %w", mctx.spec.UserDataSecret.Name, err)
+	}
+	if _, ok := secret.Data[userDataKey]; !ok {
+		return nil, fmt.Errorf("%q key not found in secret %q", userDataKey, mctx.spec.UserDataSecret.Name)
+	}
+	return secret, nil
+}
+
+// listUserDataSecrets returns the secrets matching the UserDataSecretSelector of the machine, before applying the UserDataSecretPolicy.
+func (a *Actuator) listUserDataSecrets(ctx context.Context, mctx *machineContext) ([]corev1.Secret, error) {
+	if mctx.spec.UserDataSecretSelector == nil {
+		return nil, nil
+	}
+	sel, err := metav1.LabelSelectorAsSelector(mctx.spec.UserDataSecretSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse UserDataSecretSelector: %w", err)
+	}
+	var secrets corev1.SecretList
+	if err := a.k8sClient.List(
+		ctx,
+		&secrets,
+		client.InNamespace(mctx.machine.Namespace),
+		client.MatchingLabelsSelector{Selector: sel},
+	); err != nil {
+		return nil, fmt.Errorf("failed to list secrets in namespace %q: %w", mctx.machine.Namespace, err)
+	}
+	return secrets.Items, nil
+}
+
+// listJsonnetLibrary returns the ConfigMaps and Secrets in the namespace labeled with csv1beta1.JsonnetLibraryLabel.
+// Templates without imports don't need the library, the library objects are only listed if the template imports something.
+func (a *Actuator) listJsonnetLibrary(ctx context.Context, namespace, template string) ([]corev1.ConfigMap, []corev1.Secret, error) {
+	if !jsonnetImport.MatchString(template) {
+		return nil, nil, nil
+	}
+	sel := client.MatchingLabels{csv1beta1.JsonnetLibraryLabel: "true"}
+
+	var cms corev1.ConfigMapList
+	if err := a.k8sClient.List(ctx, &cms, client.InNamespace(namespace), sel); err != nil {
+		return nil, nil, fmt.Errorf("failed to list jsonnet library config maps in namespace %q: %w", namespace, err)
+	}
+	var secrets corev1.SecretList
+	if err := a.k8sClient.List(ctx, &secrets, client.InNamespace(namespace), sel); err != nil {
+		return nil, nil, fmt.Errorf("failed to list jsonnet library secrets in namespace %q: %w", namespace, err)
+	}
+	return cms.Items, secrets.Items, nil
+}
+
+// currentUserDataSourcesVersion returns the userDataSourcesVersion of the objects the user data of the machine would currently be rendered from.
+func (a *Actuator) currentUserDataSourcesVersion(ctx context.Context, mctx *machineContext) (string, error) {
+	secret, err := a.getUserDataSecret(ctx, mctx)
+	if err != nil {
+		return "", err
+	}
+	if len(secret.Data[userDataKey]) == 0 {
+		return userDataSourcesVersion(secret, nil, nil, nil), nil
+	}
+	selected, err := a.listUserDataSecrets(ctx, mctx)
+	if err != nil {
+		return "", err
+	}
+	libConfigMaps, libSecrets, err := a.listJsonnetLibrary(ctx, mctx.machine.Namespace, string(secret.Data[userDataKey]))
+	if err != nil {
+		return "", err
+	}
+	return userDataSourcesVersion(secret, selected, libConfigMaps, libSecrets), nil
+}
+
+// userDataSourcesVersion returns a hash of the names and resource versions of the objects the user data is rendered from.
+func userDataSourcesVersion(secret *corev1.Secret, selected []corev1.Secret, libConfigMaps []corev1.ConfigMap, libSecrets []corev1.Secret) string {
+	sources := []string{"userDataSecret/" + secret.Name + "=" + secret.ResourceVersion}
+	for _, s := range selected {
+		sources = append(sources, "secret/"+s.Name+"="+s.ResourceVersion)
+	}
+	for _, cm := range libConfigMaps {
+		sources = append(sources, "libraryConfigMap/"+cm.Name+"="+cm.ResourceVersion)
+	}
+	for _, s := range libSecrets {
+		sources = append(sources, "librarySecret/"+s.Name+"="+s.ResourceVersion)
+	}
+	slices.Sort(sources)
+	return userDataHash(strings.Join(sources, "\n"))
+}
+
+// loadJsonnetLibrary collects the keys of the given Jsonnet library ConfigMaps and Secrets.
+// Every key is importable as lib/<key>. A key present in more than one object is an error.
+// Secrets blocked by the UserDataSecretPolicy are not part of the library and are added to the blocked secrets of the machine context.
+func (a *Actuator) loadJsonnetLibrary(ctx context.Context, mctx *machineContext, configMaps []corev1.ConfigMap, secrets []corev1.Secret) (*libraryImporter, error) {
+	allowed, blocked, reasons := a.userDataSecretPolicy.filter(secrets)
+	if len(blocked) > 0 {
+		log.FromContext(ctx).WithName("Actuator.loadJsonnetLibrary").Info("Jsonnet library secrets blocked by policy", "machine", mctx.machine.Name, "blocked", reasons)
+	}
+	mctx.userDataSecretPolicyApplied = true
+	for _, b := range blocked {
+		mctx.blockedUserDataSecrets = append(mctx.blockedUserDataSecrets, b+" (jsonnet library)")
+	}
+	cms := slices.Clone(configMaps)
+	secrets = allowed
+
+	imp := &libraryImporter{files: map[string]jsonnet.Contents{}}
+	sources := map[string]string{}
+	add := func(source, key, content string) error {
+		p := jsonnetLibraryImportPrefix + key
+		if other, ok := sources[p]; ok {
+			return fmt.Errorf("jsonnet library file %q is defined in both %s and %s", p, other, source)
+		}
+		sources[p] = source
+		imp.files[p] = jsonnet.MakeContents(content)
+		return nil
+	}
+	// Sort the objects and keys so a duplicate key always names the same objects.
+	slices.SortFunc(cms, func(a, b corev1.ConfigMap) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(secrets, func(a, b corev1.Secret) int { return strings.Compare(a.Name, b.Name) })
+	for _, cm := range cms {
+		for _, k := range slices.Sorted(maps.Keys(cm.Data)) {
+			if err := add(fmt.Sprintf("config map %s/%s", cm.Namespace, cm.Name), k, cm.Data[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, s := range secrets {
+		// Jsonnet errors quote lines of imported files.
+		mctx.addSensitiveSecretData(&s)
+		for _, k := range slices.Sorted(maps.Keys(s.Data)) {
+			if err := add(fmt.Sprintf("secret %s/%s", s.Namespace, s.Name), k, string(s.Data[k])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return imp, nil
+}
+
+// libraryImporter is a jsonnet.Importer serving only the files of the Jsonnet library.
+// Imports from the filesystem or from remote locations are not possible.
+type libraryImporter struct {
+	files map[string]jsonnet.Contents
+}
+
+// Import implements jsonnet.Importer.
+// Files of the library can be imported by their full path, e.g. lib/ignition.libsonnet.
+// Files inside the library can additionally import other library files relative to themselves.
+func (i *libraryImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	candidates := []string{importedPath}
+	if strings.HasPrefix(importedFrom, jsonnetLibraryImportPrefix) {
+		candidates = append([]string{path.Join(path.Dir(importedFrom), importedPath)}, candidates...)
+	}
+	for _, c := range candidates {
+		if contents, ok := i.files[c]; ok {
+			return contents, c, nil
+		}
+	}
+
+	available := make([]string, 0, len(i.files))
+	for p := range i.files {
+		available = append(available, p)
+	}
+	sort.Strings(available)
+	return jsonnet.Contents{}, "", fmt.Errorf("import %q not found: only files of the jsonnet library can be imported (available: %s)", importedPath, strings.Join(available, ", "))
+}
+
+// storeRenderedUserData stores the rendered user data in a secret controlled by the machine and references it from the provider status.
+func (a *Actuator) storeRenderedUserData(ctx context.Context, machine *machinev1beta1.Machine, userData string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      machine.Name + renderedUserDataSecretSuffix,
+			Namespace: machine.Namespace,
+		},
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, a.k8sClient, secret, func() error {
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{
+			userDataKey: []byte(userData),
+		}
+		return controllerutil.SetControllerReference(machine, secret, a.k8sClient.Scheme())
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store rendered user data in secret %q: %w", secret.Name, err)
+	}
+	log.FromContext(ctx).WithName("Actuator.storeRenderedUserData").Info("Stored rendered user data", "machine", machine.Name, "secret", secret.Name, "operation", op)
+
+	return updateProviderStatus(machine, func(status *csv1beta1.CloudscaleMachineProviderStatus) {
+		status.RenderedUserDataSecret = &corev1.LocalObjectReference{Name: secret.Name}
+	})
+}
+
+// RenderUserData renders the user data of the machine the same way Create does, without creating a server.
+// Server groups created for the AntiAffinityKey are not part of the context.
+// Jsonnet std.trace output is written to stderr.
+func (a *Actuator) RenderUserData(ctx context.Context, machine *machinev1beta1.Machine) (string, error) {
+	spec, err := csv1beta1.ProviderSpecFromRawExtension(machine.Spec.ProviderSpec.Value)
+	if err != nil {
+		return "", fmt.Errorf("failed to get provider spec from machine %q: %w", machine.Name, err)
+	}
+	if spec.UserDataSecret == nil {
+		return "", fmt.Errorf("machine %q has no userDataSecret", machine.Name)
+	}
+
+	// Multi-zone machines are rendered for the zone they were created in, or the first zone.
+	if len(spec.Zones) > 0 && !applyZone(spec, machine.Labels[machinecontroller.MachineAZLabelName]) {
+		applyZone(spec, spec.Zones[0].Zone)
+	}
+	// Machines created in a fallback zone are rendered for the fallback zone.
+	applyFallbackZone(spec, machine.Labels[machinecontroller.MachineAZLabelName])
+
+	return a.loadAndRenderUserDataSecret(ctx, &machineContext{
+		machine:      machine,
+		spec:         *spec,
+		serverGroups: spec.ServerGroups,
+	})
+}
+
+// addSensitiveSecretData adds the values of the secret to the values redacted from errors, in plain and base64 encoded form.
+// Values shorter than minRedactedFragmentLength are skipped, they are likely to be common words.
+func (mctx *machineContext) addSensitiveSecretData(secret *corev1.Secret) {
+	for _, v := range secret.Data {
+		if len(v) < minRedactedFragmentLength {
+			continue
+		}
+		mctx.sensitiveValues = append(mctx.sensitiveValues, string(v), base64.StdEncoding.EncodeToString(v))
+	}
+}
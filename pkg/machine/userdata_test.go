@@ -0,0 +1,618 @@
+package machine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/jsonnetlimits"
+)
+
+func Test_Actuator_loadAndRenderUserDataSecret_JsonnetLibrary(t *testing.T) {
+	t.Parallel()
+
+	libLabels := map[string]string{csv1beta1.JsonnetLibraryLabel: "true"}
+	libConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "jsonnet-lib", Namespace: "default", Labels: libLabels},
+		Data: map[string]string{
+			"ignition.libsonnet": `local util = import 'util.libsonnet'; { config(name):: { ignition: { version: '3.1.0' }, name: util.upper(name) } }`,
+			"util.libsonnet":     `{ upper(s):: std.asciiUpper(s) }`,
+		},
+	}
+	libSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "jsonnet-lib-secret", Namespace: "default", Labels: libLabels},
+		Data: map[string][]byte{
+			"secret.libsonnet": []byte(`{ password: 'hunter2' }`),
+		},
+	}
+	otherNamespaceLib := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "jsonnet-lib", Namespace: "other", Labels: libLabels},
+		Data: map[string]string{
+			"other.libsonnet": `{}`,
+		},
+	}
+	unlabeledConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "unlabeled", Namespace: "default"},
+		Data: map[string]string{
+			"unlabeled.libsonnet": `{}`,
+		},
+	}
+
+	tcs := []struct {
+		name      string
+		userData  string
+		extraObjs []*corev1.ConfigMap
+		expected  string
+		errorMsg  string
+	}{
+		{
+			name:     "library imports",
+			userData: `local ign = import 'lib/ignition.libsonnet'; local s = import 'lib/secret.libsonnet'; ign.config('app') + { pw: s.password }`,
+			expected: `{"ignition":{"version":"3.1.0"},"name":"APP","pw":"hunter2"}`,
+		},
+		{
+			name:     "importstr",
+			userData: `{ util: importstr 'lib/util.libsonnet' }`,
+			expected: `{"util":"{ upper(s):: std.asciiUpper(s) }"}`,
+		},
+		{
+			name:     "import without prefix",
+			userData: `import 'util.libsonnet'`,
+			errorMsg: `import "util.libsonnet" not found`,
+		},
+		{
+			name:     "import from other namespace",
+			userData: `import 'lib/other.libsonnet'`,
+			errorMsg: `import "lib/other.libsonnet" not found`,
+		},
+		{
+			name:     "import of unlabeled config map",
+			userData: `import 'lib/unlabeled.libsonnet'`,
+			errorMsg: `import "lib/unlabeled.libsonnet" not found`,
+		},
+		{
+			name:     "filesystem import",
+			userData: `importstr '/etc/passwd'`,
+			errorMsg: `import "/etc/passwd" not found`,
+		},
+		{
+			name:     "path traversal",
+			userData: `importstr 'lib/../../etc/passwd'`,
+			errorMsg: `import "lib/../../etc/passwd" not found`,
+		},
+		{
+			name:     "remote import",
+			userData: `import 'https://example.com/lib.libsonnet'`,
+			errorMsg: `import "https://example.com/lib.libsonnet" not found`,
+		},
+		{
+			name:     "duplicate library file",
+			userData: `import 'lib/util.libsonnet'`,
+			extraObjs: []*corev1.ConfigMap{{
+				ObjectMeta: metav1.ObjectMeta{Name: "jsonnet-lib-2", Namespace: "default", Labels: libLabels},
+				Data: map[string]string{
+					"util.libsonnet": `{}`,
+				},
+			}},
+			errorMsg: `jsonnet library file "lib/util.libsonnet" is defined in both config map default/jsonnet-lib and config map default/jsonnet-lib-2`,
+		},
+		{
+			name:     "template without imports doesn't load the library",
+			userData: `{ ignition: { version: '3.1.0' } }`,
+			extraObjs: []*corev1.ConfigMap{{
+				ObjectMeta: metav1.ObjectMeta{Name: "jsonnet-lib-2", Namespace: "default", Labels: libLabels},
+				Data: map[string]string{
+					"util.libsonnet": `{}`,
+				},
+			}},
+			expected: `{"ignition":{"version":"3.1.0"}}`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+			}
+			userDataSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+				Data: map[string][]byte{
+					"userData": []byte(tc.userData),
+				},
+			}
+
+			objs := []runtime.Object{userDataSecret, libConfigMap, libSecret, otherNamespaceLib, unlabeledConfigMap}
+			for _, o := range tc.extraObjs {
+				objs = append(objs, o)
+			}
+			actuator := newActuator(newFakeClient(t, objs...), nil, nil, nil)
+
+			ud, err := actuator.loadAndRenderUserDataSecret(ctx, &machineContext{
+				machine: machine,
+				spec: csv1beta1.CloudscaleMachineProviderSpec{
+					UserDataSecret: &corev1.LocalObjectReference{Name: userDataSecret.Name},
+				},
+			})
+			if tc.errorMsg != "" {
+				require.ErrorContains(t, err, tc.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ud)
+		})
+	}
+}
+
+func Test_Actuator_loadAndRenderUserDataSecret_JsonnetLibrarySecretRedacted(t *testing.T) {
+	t.Parallel()
+
+	libSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "jsonnet-lib-secret", Namespace: "default", Labels: map[string]string{csv1beta1.JsonnetLibraryLabel: "true"}},
+		Data: map[string][]byte{
+			"password.txt": []byte("correct-horse-battery-staple\n"),
+		},
+	}
+	userDataSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+		Data: map[string][]byte{
+			"userData": []byte(`error 'invalid password ' + importstr 'lib/password.txt'`),
+		},
+	}
+	actuator := newActuator(newFakeClient(t, userDataSecret, libSecret), nil, nil, nil)
+
+	mctx := &machineContext{
+		machine: &machinev1beta1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"}},
+		spec: csv1beta1.CloudscaleMachineProviderSpec{
+			UserDataSecret: &corev1.LocalObjectReference{Name: userDataSecret.Name},
+		},
+	}
+	_, err := actuator.loadAndRenderUserDataSecret(t.Context(), mctx)
+	require.ErrorContains(t, err, "correct-horse-battery-staple")
+	redacted := mctx.redactError(err)
+	assert.ErrorContains(t, redacted, "invalid password")
+	assert.NotContains(t, redacted.Error(), "correct-horse-battery-staple")
+}
+
+func Test_Actuator_loadAndRenderUserDataSecret_UserDataFormat(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name     string
+		format   csv1beta1.UserDataFormat
+		userData string
+		expected string
+		errorMsg string
+	}{
+		{
+			name:     "default",
+			userData: `{ ignition: { version: '3.1.0' } }`,
+			expected: `{"ignition":{"version":"3.1.0"}}`,
+		},
+		{
+			name:     "json",
+			format:   csv1beta1.UserDataFormatJSON,
+			userData: `{ ignition: { version: '3.1.0' } }`,
+			expected: `{"ignition":{"version":"3.1.0"}}`,
+		},
+		{
+			name:     "yaml",
+			format:   csv1beta1.UserDataFormatYAML,
+			userData: `{ packages: ['curl'], hostname: std.extVar('context').machine.metadata.name }`,
+			expected: "hostname: app-test\npackages:\n- curl\n",
+		},
+		{
+			name:     "cloud-init",
+			format:   csv1beta1.UserDataFormatCloudInit,
+			userData: `{ packages: ['curl'] }`,
+			expected: "#cloud-config\npackages:\n- curl\n",
+		},
+		{
+			name:     "raw",
+			format:   csv1beta1.UserDataFormatRaw,
+			userData: `"#!/bin/sh\necho %s\n" % std.extVar('context').machine.metadata.name`,
+			expected: "#!/bin/sh\necho app-test\n",
+		},
+		{
+			name:     "raw manifest",
+			format:   csv1beta1.UserDataFormatRaw,
+			userData: `std.manifestIni({ sections: { main: { a: 1 } } })`,
+			expected: "[main]\na = 1\n",
+		},
+		{
+			name:     "raw requires string",
+			format:   csv1beta1.UserDataFormatRaw,
+			userData: `{ a: 1 }`,
+			errorMsg: "failed to evaluate jsonnet",
+		},
+		{
+			name:     "unknown format",
+			format:   "toml",
+			userData: `{}`,
+			errorMsg: `unknown user data format "toml"`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+			}
+			userDataSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+				Data: map[string][]byte{
+					"userData": []byte(tc.userData),
+				},
+			}
+			actuator := newActuator(newFakeClient(t, userDataSecret), nil, nil, nil)
+
+			ud, err := actuator.loadAndRenderUserDataSecret(context.Background(), &machineContext{
+				machine: machine,
+				spec: csv1beta1.CloudscaleMachineProviderSpec{
+					UserDataSecret: &corev1.LocalObjectReference{Name: userDataSecret.Name},
+					UserDataFormat: tc.format,
+				},
+			})
+			if tc.errorMsg != "" {
+				require.ErrorContains(t, err, tc.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ud)
+		})
+	}
+}
+
+func Test_Actuator_loadAndRenderUserDataSecret_MaxUserDataSize(t *testing.T) {
+	t.Parallel()
+
+	const maxSize = 512
+	compressible := func(version string) string {
+		return `{ ignition: { version: '` + version + `' }, storage: { files: [{ path: '/etc/motd', contents: { source: 'data:,' + std.repeat('a', 1000) } }] } }`
+	}
+	incompressible := `{ ignition: { version: '3.1.0' }, data: std.join('', [std.md5(std.toString(i)) for i in std.range(0, 200)]) }`
+
+	tcs := []struct {
+		name     string
+		format   csv1beta1.UserDataFormat
+		compress bool
+		userData string
+		// expected is the expected user data if it is not compressed
+		expected string
+		// version is the ignition version of compressible user data
+		version string
+		// wrapperVersion is the expected ignition version of the compressed user data
+		wrapperVersion string
+		errorMsg       string
+	}{
+		{
+			name:     "fits",
+			userData: `{ ignition: { version: '3.1.0' } }`,
+			expected: `{"ignition":{"version":"3.1.0"}}`,
+		},
+		{
+			name:     "too large",
+			userData: compressible("3.1.0"),
+			errorMsg: "rendered user data is 1106 bytes, exceeds the maximum of 512 bytes; consider setting compressUserData",
+		},
+		{
+			name:           "compressed",
+			compress:       true,
+			version:        "3.1.0",
+			userData:       compressible("3.1.0"),
+			wrapperVersion: "3.1.0",
+		},
+		{
+			name:           "compressed newer spec",
+			compress:       true,
+			version:        "3.4.0",
+			userData:       compressible("3.4.0"),
+			wrapperVersion: "3.4.0",
+		},
+		{
+			name:           "compressed older spec",
+			compress:       true,
+			version:        "3.0.0",
+			userData:       compressible("3.0.0"),
+			wrapperVersion: "3.1.0",
+		},
+		{
+			name:     "compression not supported for spec 2",
+			compress: true,
+			userData: compressible("2.2.0"),
+			errorMsg: `compression is only supported for ignition spec 3 configs, got version "2.2.0"`,
+		},
+		{
+			name:     "compression not supported without ignition version",
+			compress: true,
+			userData: `{ data: std.repeat('a', 1000) }`,
+			errorMsg: `compression is only supported for ignition configs`,
+		},
+		{
+			name:     "too large compressed",
+			compress: true,
+			userData: incompressible,
+			errorMsg: "exceeds the maximum of 512 bytes",
+		},
+		{
+			name:     "compression not supported for format",
+			format:   csv1beta1.UserDataFormatCloudInit,
+			compress: true,
+			userData: `{ data: std.repeat('a', 1000) }`,
+			errorMsg: `compression is only supported for the "json" user data format`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+			}
+			userDataSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+				Data: map[string][]byte{
+					"userData": []byte(tc.userData),
+				},
+			}
+			actuator := newActuator(newFakeClient(t, userDataSecret), nil, nil, nil)
+			actuator.maxUserDataSize = maxSize
+
+			ud, err := actuator.loadAndRenderUserDataSecret(context.Background(), &machineContext{
+				machine: machine,
+				spec: csv1beta1.CloudscaleMachineProviderSpec{
+					UserDataSecret:   &corev1.LocalObjectReference{Name: userDataSecret.Name},
+					UserDataFormat:   tc.format,
+					CompressUserData: tc.compress,
+				},
+			})
+			if tc.errorMsg != "" {
+				require.ErrorContains(t, err, tc.errorMsg)
+				var merr *machinecontroller.MachineError
+				require.ErrorAs(t, err, &merr, "size errors should be terminal")
+				assert.Equal(t, machinev1beta1.InvalidConfigurationMachineError, merr.Reason)
+				return
+			}
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(ud), maxSize)
+			if !tc.compress {
+				assert.Equal(t, tc.expected, ud)
+				return
+			}
+
+			var wrapper struct {
+				Ignition struct {
+					Version string
+					Config  struct {
+						Merge []struct {
+							Compression string
+							Source      string
+						}
+					}
+				}
+			}
+			require.NoError(t, json.Unmarshal([]byte(ud), &wrapper))
+			assert.Equal(t, tc.wrapperVersion, wrapper.Ignition.Version)
+			require.Len(t, wrapper.Ignition.Config.Merge, 1)
+			assert.Equal(t, "gzip", wrapper.Ignition.Config.Merge[0].Compression)
+			enc, ok := strings.CutPrefix(wrapper.Ignition.Config.Merge[0].Source, "data:;base64,")
+			require.True(t, ok, "source should be a base64 data URL")
+			gz, err := base64.StdEncoding.DecodeString(enc)
+			require.NoError(t, err)
+			zr, err := gzip.NewReader(bytes.NewReader(gz))
+			require.NoError(t, err)
+			decompressed, err := io.ReadAll(zr)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"ignition":{"version":"`+tc.version+`"},"storage":{"files":[{"path":"/etc/motd","contents":{"source":"data:,`+strings.Repeat("a", 1000)+`"}}]}}`, string(decompressed))
+		})
+	}
+}
+
+func Test_Actuator_RenderUserData(t *testing.T) {
+	t.Parallel()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+	}
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{
+		UserDataSecret: &corev1.LocalObjectReference{Name: "app-user-data"},
+		UserDataFormat: csv1beta1.UserDataFormatYAML,
+	})
+	userDataSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+		Data: map[string][]byte{
+			"userData": []byte(`{ hostname: std.extVar('context').machine.metadata.name }`),
+		},
+	}
+	actuator := newActuator(newFakeClient(t, userDataSecret), nil, nil, nil)
+
+	ud, err := actuator.RenderUserData(context.Background(), machine)
+	require.NoError(t, err)
+	assert.Equal(t, "hostname: app-test\n", ud)
+
+	setProviderSpecOnMachine(t, machine, &csv1beta1.CloudscaleMachineProviderSpec{})
+	_, err = actuator.RenderUserData(context.Background(), machine)
+	require.ErrorContains(t, err, "has no userDataSecret")
+}
+
+func Test_Actuator_loadAndRenderUserDataSecret_Context(t *testing.T) {
+	t.Parallel()
+
+	machineSet := &machinev1beta1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "ms-uid"},
+		Spec:       machinev1beta1.MachineSetSpec{Replicas: ptr.To[int32](3)},
+	}
+	infra := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Status: configv1.InfrastructureStatus{
+			APIServerInternalURL: "https://api-int.cluster.example.com:6443",
+		},
+	}
+
+	tcs := []struct {
+		name     string
+		userData string
+		objs     []runtime.Object
+		owned    bool
+		expected string
+		// expectedGets is the number of expected get requests for MachineSets and Infrastructures
+		expectedGets int
+	}{
+		{
+			name:     "static keys",
+			userData: `local c = std.extVar('context'); { zone: c.zone, region: c.region, sg: c.serverGroups, v: c.providerVersion }`,
+			objs:     []runtime.Object{machineSet, infra},
+			owned:    true,
+			expected: `{"region":"rma","sg":["sg-1","sg-2"],"v":"v1.2.3","zone":"rma1"}`,
+		},
+		{
+			name:         "lazy keys",
+			userData:     `local c = std.extVar('context'); { replicas: c.machineSet.spec.replicas, api: c.infrastructure.status.apiServerInternalURI, again: c.machineSet.metadata.name }`,
+			objs:         []runtime.Object{machineSet, infra},
+			owned:        true,
+			expected:     `{"again":"app","api":"https://api-int.cluster.example.com:6443","replicas":3}`,
+			expectedGets: 2,
+		},
+		{
+			name:         "not owned and no infrastructure",
+			userData:     `local c = std.extVar('context'); { ms: c.machineSet, infra: c.infrastructure }`,
+			expected:     `{"infra":null,"ms":null}`,
+			expectedGets: 1,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			machine := &machinev1beta1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+			}
+			if tc.owned {
+				machine.OwnerReferences = []metav1.OwnerReference{
+					*metav1.NewControllerRef(machineSet, machinev1beta1.GroupVersion.WithKind("MachineSet")),
+				}
+			}
+			userDataSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+				Data: map[string][]byte{
+					"userData": []byte(tc.userData),
+				},
+			}
+
+			var gets atomic.Int32
+			c := fake.NewClientBuilder().
+				WithScheme(testScheme).
+				WithRuntimeObjects(append(tc.objs, userDataSecret)...).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+						switch obj.(type) {
+						case *machinev1beta1.MachineSet, *configv1.Infrastructure:
+							gets.Add(1)
+						}
+						return c.Get(ctx, key, obj, opts...)
+					},
+				}).
+				Build()
+			actuator := NewActuator(ActuatorParams{
+				K8sClient:       c,
+				ProviderVersion: "v1.2.3",
+			})
+
+			ud, err := actuator.loadAndRenderUserDataSecret(context.Background(), &machineContext{
+				machine: machine,
+				spec: csv1beta1.CloudscaleMachineProviderSpec{
+					UserDataSecret: &corev1.LocalObjectReference{Name: userDataSecret.Name},
+					Zone:           "rma1",
+				},
+				serverGroups: []string{"sg-1", "sg-2"},
+			})
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, ud)
+			assert.EqualValues(t, tc.expectedGets, gets.Load(), "objects should only be fetched once and only if accessed")
+		})
+	}
+}
+
+func Test_Actuator_loadAndRenderUserDataSecret_JsonnetLimits(t *testing.T) {
+	t.Parallel()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default"},
+	}
+	userDataSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-user-data", Namespace: "default"},
+		Data: map[string][]byte{
+			"userData": []byte(`local f(n) = if n == 0 then {} else f(n - 1) + { ['k%d' % n]: n }; f(std.parseInt(std.extVar('context').data.depth))`),
+			"depth":    []byte("20"),
+		},
+	}
+	actuator := NewActuator(ActuatorParams{
+		K8sClient:     newFakeClient(t, userDataSecret),
+		JsonnetLimits: jsonnetlimits.Limits{MaxStack: 10},
+	})
+	mctx := &machineContext{
+		machine: machine,
+		spec: csv1beta1.CloudscaleMachineProviderSpec{
+			UserDataSecret: &corev1.LocalObjectReference{Name: userDataSecret.Name},
+		},
+	}
+
+	_, err := actuator.loadAndRenderUserDataSecret(context.Background(), mctx)
+	require.ErrorContains(t, err, "max stack frames exceeded")
+
+	actuator.jsonnetLimits = jsonnetlimits.Limits{MaxOutputSize: 50}
+	_, err = actuator.loadAndRenderUserDataSecret(context.Background(), mctx)
+	require.ErrorIs(t, err, jsonnetlimits.ErrOutputTooLarge)
+}
+
+func Test_Actuator_storeRenderedUserData(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-test", Namespace: "default", UID: "machine-uid"},
+	}
+	c := newFakeClient(t, machine)
+	actuator := newActuator(c, nil, nil, nil)
+
+	require.NoError(t, actuator.storeRenderedUserData(ctx, machine, `{"ignition":{"version":"3.1.0"}}`))
+	// Retried creates update the secret
+	require.NoError(t, actuator.storeRenderedUserData(ctx, machine, `{"ignition":{"version":"3.2.0"}}`))
+
+	secret := &corev1.Secret{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "app-test-rendered-user-data", Namespace: "default"}, secret))
+	assert.Equal(t, `{"ignition":{"version":"3.2.0"}}`, string(secret.Data["userData"]))
+	assert.True(t, metav1.IsControlledBy(secret, machine), "secret should be garbage collected with the machine")
+
+	status, err := csv1beta1.ProviderStatusFromRawExtension(machine.Status.ProviderStatus)
+	require.NoError(t, err)
+	assert.Equal(t, &corev1.LocalObjectReference{Name: "app-test-rendered-user-data"}, status.RenderedUserDataSecret)
+}
@@ -0,0 +1,96 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+// selectZone chooses the zone of a machine with multiple Zones and applies it to the spec of the machine context.
+// A zone already recorded in the zone label of the machine is kept, so retries stay in the same zone.
+// Otherwise the zone with the fewest machines of the MachineSet is chosen and immediately recorded in the zone label,
+// so that the following creates of the MachineSet count it.
+func (a *Actuator) selectZone(ctx context.Context, machine *machinev1beta1.Machine, mctx *machineContext) error {
+	if len(mctx.spec.Zones) == 0 {
+		return nil
+	}
+	if mctx.spec.Zone != "" {
+		return machinecontroller.InvalidMachineConfiguration("zone and zones are mutually exclusive")
+	}
+
+	if applyZone(&mctx.spec, machine.Labels[machinecontroller.MachineAZLabelName]) {
+		return nil
+	}
+
+	counts, err := a.machineSetZoneCounts(ctx, machine)
+	if err != nil {
+		return err
+	}
+	zone := mctx.spec.Zones[0].Zone
+	for _, z := range mctx.spec.Zones[1:] {
+		if counts[z.Zone] < counts[zone] {
+			zone = z.Zone
+		}
+	}
+	log.FromContext(ctx).WithName("Actuator.selectZone").Info("Selected zone", "machine", machine.Name, "zone", zone, "machinesPerZone", counts)
+
+	patched := machine.DeepCopy()
+	metav1.SetMetaDataLabel(&patched.ObjectMeta, machinecontroller.MachineAZLabelName, zone)
+	if err := a.k8sClient.Patch(ctx, patched, client.MergeFrom(machine)); err != nil {
+		return fmt.Errorf("failed to record zone on machine %q: %w", machine.Name, err)
+	}
+	metav1.SetMetaDataLabel(&machine.ObjectMeta, machinecontroller.MachineAZLabelName, zone)
+
+	applyZone(&mctx.spec, zone)
+	return nil
+}
+
+// machineSetZoneCounts returns the number of machines per zone label controlled by the same MachineSet as the machine.
+// Returns an empty map if the machine is not controlled by a MachineSet.
+func (a *Actuator) machineSetZoneCounts(ctx context.Context, machine *machinev1beta1.Machine) (map[string]int, error) {
+	counts := make(map[string]int)
+	owner := metav1.GetControllerOf(machine)
+	if owner == nil || owner.Kind != "MachineSet" {
+		return counts, nil
+	}
+
+	var machines machinev1beta1.MachineList
+	if err := a.k8sClient.List(ctx, &machines, client.InNamespace(machine.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list machines: %w", err)
+	}
+	for _, m := range machines.Items {
+		if m.Name == machine.Name || !m.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if o := metav1.GetControllerOf(&m); o == nil || o.UID != owner.UID {
+			continue
+		}
+		if zone := m.Labels[machinecontroller.MachineAZLabelName]; zone != "" {
+			counts[zone]++
+		}
+	}
+	return counts, nil
+}
+
+// applyZone sets the zone of a spec with multiple Zones and replaces the interfaces with the ones of the zone, if set.
+// Returns false if the zone is not in Zones.
+func applyZone(spec *csv1beta1.CloudscaleMachineProviderSpec, zone string) bool {
+	for _, z := range spec.Zones {
+		if z.Zone != zone {
+			continue
+		}
+		spec.Zone = z.Zone
+		if z.Interfaces != nil {
+			spec.Interfaces = z.Interfaces
+		}
+		return true
+	}
+	return false
+}
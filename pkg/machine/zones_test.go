@@ -0,0 +1,131 @@
+package machine
+
+import (
+	"errors"
+	"testing"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	machinecontroller "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	csv1beta1 "github.com/appuio/machine-api-provider-cloudscale/api/cloudscale/provider/v1beta1"
+)
+
+func Test_Actuator_selectZone(t *testing.T) {
+	const ns = "openshift-machine-api"
+	newMachine := func(name string, ownerUID types.UID, zone string) *machinev1beta1.Machine {
+		m := &machinev1beta1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+			},
+		}
+		if ownerUID != "" {
+			m.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: machinev1beta1.GroupVersion.String(),
+				Kind:       "MachineSet",
+				Name:       "ms-" + string(ownerUID),
+				UID:        ownerUID,
+				Controller: ptr.To(true),
+			}}
+		}
+		if zone != "" {
+			m.Labels = map[string]string{machinecontroller.MachineAZLabelName: zone}
+		}
+		return m
+	}
+	lpgInterfaces := []csv1beta1.Interface{{Type: csv1beta1.InterfaceTypePrivate, NetworkUUID: "lpg-net"}}
+	zones := []csv1beta1.MachineZone{
+		{Zone: "rma1"},
+		{Zone: "lpg1", Interfaces: lpgInterfaces},
+	}
+
+	tcs := []struct {
+		name    string
+		machine *machinev1beta1.Machine
+		others  []runtime.Object
+		zone    string
+
+		expectedZone       string
+		expectedInterfaces []csv1beta1.Interface
+		expectTerminal     bool
+	}{
+		{
+			name:    "fewest machines of the MachineSet",
+			machine: newMachine("app-new", "ms", ""),
+			others: []runtime.Object{
+				newMachine("app-1", "ms", "rma1"),
+				newMachine("app-2", "ms", "rma1"),
+				newMachine("app-3", "ms", "lpg1"),
+				newMachine("other-1", "other-ms", "lpg1"),
+				newMachine("other-2", "other-ms", "lpg1"),
+			},
+			expectedZone:       "lpg1",
+			expectedInterfaces: lpgInterfaces,
+		},
+		{
+			name:    "first zone on a tie",
+			machine: newMachine("app-new", "ms", ""),
+			others: []runtime.Object{
+				newMachine("app-1", "ms", "rma1"),
+				newMachine("app-2", "ms", "lpg1"),
+			},
+			expectedZone:       "rma1",
+			expectedInterfaces: []csv1beta1.Interface{{Type: csv1beta1.InterfaceTypePublic}},
+		},
+		{
+			name:    "keeps recorded zone",
+			machine: newMachine("app-new", "ms", "lpg1"),
+			others: []runtime.Object{
+				newMachine("app-1", "ms", "lpg1"),
+			},
+			expectedZone:       "lpg1",
+			expectedInterfaces: lpgInterfaces,
+		},
+		{
+			name:               "machine without MachineSet",
+			machine:            newMachine("app-new", "", ""),
+			expectedZone:       "rma1",
+			expectedInterfaces: []csv1beta1.Interface{{Type: csv1beta1.InterfaceTypePublic}},
+		},
+		{
+			name:           "zone and zones",
+			machine:        newMachine("app-new", "ms", ""),
+			zone:           "rma1",
+			expectTerminal: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeClient(t, append(tc.others, tc.machine)...)
+			a := &Actuator{k8sClient: c}
+			mctx := &machineContext{spec: csv1beta1.CloudscaleMachineProviderSpec{
+				Zone:       tc.zone,
+				Zones:      zones,
+				Interfaces: []csv1beta1.Interface{{Type: csv1beta1.InterfaceTypePublic}},
+			}}
+
+			err := a.selectZone(t.Context(), tc.machine, mctx)
+			if tc.expectTerminal {
+				var merr *machinecontroller.MachineError
+				require.True(t, errors.As(err, &merr), "expected terminal error, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedZone, mctx.spec.Zone)
+			assert.Equal(t, tc.expectedInterfaces, mctx.spec.Interfaces)
+
+			var persisted machinev1beta1.Machine
+			require.NoError(t, c.Get(t.Context(), client.ObjectKeyFromObject(tc.machine), &persisted))
+			assert.Equal(t, tc.expectedZone, persisted.Labels[machinecontroller.MachineAZLabelName])
+			assert.Equal(t, tc.expectedZone, tc.machine.Labels[machinecontroller.MachineAZLabelName])
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/appuio/machine-api-provider-cloudscale/pkg/machine"
+)
+
+// runRenderUserData renders the user data of a Machine or of a Machine created from a MachineSet and prints it to stdout.
+// The objects are read from the given manifest files, or from the cluster configured by the kubeconfig if no manifests are given.
+func runRenderUserData(manifests, name, namespace string, actuatorParams machine.ActuatorParams) {
+	ctx := ctrl.SetupSignalHandler()
+
+	ud, err := renderUserData(ctx, manifests, name, namespace, actuatorParams)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(ud)
+	if !strings.HasSuffix(ud, "\n") {
+		fmt.Println()
+	}
+}
+
+func renderUserData(ctx context.Context, manifests, name, namespace string, actuatorParams machine.ActuatorParams) (string, error) {
+	var c client.Client
+	if manifests != "" {
+		objs, err := readManifests(strings.Split(manifests, ","))
+		if err != nil {
+			return "", err
+		}
+		if name == "" {
+			name, namespace, err = singleMachineOrMachineSet(objs)
+			if err != nil {
+				return "", err
+			}
+		}
+		if namespace == "" {
+			namespace = "default"
+		}
+		for _, obj := range objs {
+			if o, ok := obj.(client.Object); ok && o.GetNamespace() == "" {
+				o.SetNamespace(namespace)
+			}
+		}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+	} else {
+		if name == "" {
+			return "", errors.New("--render-machine is required when reading from the cluster")
+		}
+		cfg, err := ctrl.GetConfig()
+		if err != nil {
+			return "", fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
+		c, err = client.New(cfg, client.Options{Scheme: scheme})
+		if err != nil {
+			return "", fmt.Errorf("failed to create client: %w", err)
+		}
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	m, err := machineOrMachineFromMachineSet(ctx, c, client.ObjectKey{Name: name, Namespace: namespace})
+	if err != nil {
+		return "", err
+	}
+
+	actuatorParams.K8sClient = c
+	actuatorParams.ProviderVersion = providerVersion()
+	return machine.NewActuator(actuatorParams).RenderUserData(ctx, m)
+}
+
+// machineOrMachineFromMachineSet returns the Machine with the given key.
+// If no such Machine exists, a Machine is created from the template of the MachineSet with the given key.
+func machineOrMachineFromMachineSet(ctx context.Context, c client.Client, key client.ObjectKey) (*machinev1beta1.Machine, error) {
+	m := &machinev1beta1.Machine{}
+	err := c.Get(ctx, key, m)
+	if err == nil {
+		return m, nil
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("failed to get machine %q: %w", key, err)
+	}
+
+	ms := &machinev1beta1.MachineSet{}
+	if err := c.Get(ctx, key, ms); err != nil {
+		return nil, fmt.Errorf("failed to get machine or machine set %q: %w", key, err)
+	}
+	tmpl := ms.Spec.Template
+	return &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			// Machines created by the MachineSet controller get a random suffix
+			Name:        ms.Name + "-render",
+			Namespace:   ms.Namespace,
+			Labels:      tmpl.Labels,
+			Annotations: tmpl.Annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(ms, machinev1beta1.GroupVersion.WithKind("MachineSet")),
+			},
+		},
+		Spec: tmpl.Spec,
+	}, nil
+}
+
+// singleMachineOrMachineSet returns the name and namespace of the only Machine or MachineSet in the given objects.
+func singleMachineOrMachineSet(objs []runtime.Object) (name, namespace string, err error) {
+	var found []client.Object
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *machinev1beta1.Machine:
+			found = append(found, o)
+		case *machinev1beta1.MachineSet:
+			found = append(found, o)
+		}
+	}
+	if len(found) != 1 {
+		return "", "", fmt.Errorf("expected exactly one Machine or MachineSet in the manifests, found %d; use --render-machine to select one", len(found))
+	}
+	return found[0].GetName(), found[0].GetNamespace(), nil
+}
+
+// readManifests decodes all objects from the given YAML or JSON files.
+// A file name of - reads from stdin.
+func readManifests(files []string) ([]runtime.Object, error) {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+
+	var objs []runtime.Object
+	for _, f := range files {
+		fobjs, err := readManifest(decoder, f)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, fobjs...)
+	}
+	return objs, nil
+}
+
+// readManifest decodes all objects from the given YAML or JSON file.
+// A file name of - reads from stdin.
+func readManifest(decoder runtime.Decoder, f string) ([]runtime.Object, error) {
+	var r io.Reader = os.Stdin
+	if f != "-" {
+		fh, err := os.Open(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open manifest: %w", err)
+		}
+		defer fh.Close()
+		r = fh
+	}
+
+	var objs []runtime.Object
+	yr := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := yr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest %q: %w", f, err)
+		}
+		if len(strings.TrimSpace(string(doc))) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest %q: %w", f, err)
+		}
+		if s, ok := obj.(*corev1.Secret); ok {
+			// The API server merges stringData into data on write
+			for k, v := range s.StringData {
+				if s.Data == nil {
+					s.Data = map[string][]byte{}
+				}
+				s.Data[k] = []byte(v)
+			}
+			s.StringData = nil
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
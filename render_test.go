@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	machineManifest = `apiVersion: machine.openshift.io/v1beta1
+kind: Machine
+metadata:
+  name: app-1
+  namespace: openshift-machine-api
+`
+	machineSetManifest = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: app
+spec:
+  template:
+    metadata:
+      labels:
+        role: app
+`
+	secretManifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: app-user-data
+stringData:
+  userData: "{}"
+`
+	configMapManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: jsonnet-lib
+data:
+  lib.libsonnet: "{}"
+`
+)
+
+func Test_readManifests(t *testing.T) {
+	tcs := []struct {
+		name     string
+		files    []string
+		expected []string
+		errorMsg string
+	}{
+		{
+			name:     "single machine",
+			files:    []string{machineManifest},
+			expected: []string{"Machine/app-1"},
+		},
+		{
+			name:     "machine set",
+			files:    []string{machineSetManifest},
+			expected: []string{"MachineSet/app"},
+		},
+		{
+			name:     "several documents",
+			files:    []string{machineSetManifest + "---\n" + secretManifest + "---\n", configMapManifest},
+			expected: []string{"MachineSet/app", "Secret/app-user-data", "ConfigMap/jsonnet-lib"},
+		},
+		{
+			name:     "no machine",
+			files:    []string{secretManifest + "---\n" + configMapManifest},
+			expected: []string{"Secret/app-user-data", "ConfigMap/jsonnet-lib"},
+		},
+		{
+			name:     "unknown kind",
+			files:    []string{"apiVersion: example.com/v1\nkind: Unknown\nmetadata:\n  name: x\n"},
+			errorMsg: "failed to decode manifest",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := make([]string, len(tc.files))
+			for i, content := range tc.files {
+				files[i] = filepath.Join(dir, fmt.Sprintf("manifest-%d.yaml", i))
+				require.NoError(t, os.WriteFile(files[i], []byte(content), 0o600))
+			}
+
+			objs, err := readManifests(files)
+			if tc.errorMsg != "" {
+				require.ErrorContains(t, err, tc.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+
+			names := make([]string, len(objs))
+			for i, obj := range objs {
+				o := obj.(client.Object)
+				names[i] = obj.GetObjectKind().GroupVersionKind().Kind + "/" + o.GetName()
+				if s, ok := obj.(*corev1.Secret); ok {
+					assert.Equal(t, map[string][]byte{"userData": []byte("{}")}, s.Data, "stringData should be merged into data")
+					assert.Nil(t, s.StringData)
+				}
+			}
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+}
+
+func Test_singleMachineOrMachineSet(t *testing.T) {
+	machine := &machinev1beta1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "openshift-machine-api"}}
+	machineSet := &machinev1beta1.MachineSet{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app-user-data"}}
+
+	tcs := []struct {
+		name              string
+		objs              []runtime.Object
+		expectedName      string
+		expectedNamespace string
+		errorMsg          string
+	}{
+		{
+			name:              "single machine",
+			objs:              []runtime.Object{secret, machine},
+			expectedName:      "app-1",
+			expectedNamespace: "openshift-machine-api",
+		},
+		{
+			name:         "machine set",
+			objs:         []runtime.Object{machineSet, secret},
+			expectedName: "app",
+		},
+		{
+			name:     "several machines",
+			objs:     []runtime.Object{machine, machineSet},
+			errorMsg: "found 2",
+		},
+		{
+			name:     "no machine",
+			objs:     []runtime.Object{secret},
+			errorMsg: "found 0",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			name, namespace, err := singleMachineOrMachineSet(tc.objs)
+			if tc.errorMsg != "" {
+				require.ErrorContains(t, err, tc.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedNamespace, namespace)
+		})
+	}
+}
+
+func Test_machineOrMachineFromMachineSet(t *testing.T) {
+	const ns = "openshift-machine-api"
+
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: ns},
+		Spec:       machinev1beta1.MachineSpec{ProviderID: ptr.To("cloudscale:///app-1")},
+	}
+	machineSet := &machinev1beta1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: ns, UID: "ms-uid"},
+		Spec: machinev1beta1.MachineSetSpec{
+			Template: machinev1beta1.MachineTemplateSpec{
+				ObjectMeta: machinev1beta1.ObjectMeta{Labels: map[string]string{"role": "app"}},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(machine, machineSet).Build()
+
+	t.Run("machine", func(t *testing.T) {
+		m, err := machineOrMachineFromMachineSet(t.Context(), c, client.ObjectKey{Name: "app-1", Namespace: ns})
+		require.NoError(t, err)
+		assert.Equal(t, "cloudscale:///app-1", *m.Spec.ProviderID)
+	})
+	t.Run("machine set", func(t *testing.T) {
+		m, err := machineOrMachineFromMachineSet(t.Context(), c, client.ObjectKey{Name: "app", Namespace: ns})
+		require.NoError(t, err)
+		assert.Equal(t, "app-render", m.Name)
+		assert.Equal(t, ns, m.Namespace)
+		assert.Equal(t, map[string]string{"role": "app"}, m.Labels)
+		owner := metav1.GetControllerOf(m)
+		require.NotNil(t, owner)
+		assert.Equal(t, "MachineSet", owner.Kind)
+		assert.Equal(t, "app", owner.Name)
+	})
+	t.Run("not found", func(t *testing.T) {
+		_, err := machineOrMachineFromMachineSet(t.Context(), c, client.ObjectKey{Name: "db", Namespace: ns})
+		require.ErrorContains(t, err, `failed to get machine or machine set "openshift-machine-api/db"`)
+	})
+}